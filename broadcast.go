@@ -0,0 +1,30 @@
+package circbuf
+
+// Broadcaster fans out the data written to a SyncBuffer to any number of
+// subscribers, each reading the stream at its own pace through a Cursor.
+// Slow subscribers miss the data overwritten before they read it.
+type Broadcaster struct {
+	sb *SyncBuffer
+}
+
+// NewBroadcaster returns a Broadcaster writing to sb.
+func NewBroadcaster(sb *SyncBuffer) *Broadcaster {
+	return &Broadcaster{sb: sb}
+}
+
+// Write writes p to the buffer and wakes up the subscribers waiting for
+// data.
+func (bc *Broadcaster) Write(p []byte) (int, error) {
+	return bc.sb.Write(p)
+}
+
+// Subscribe returns a cursor positioned on the oldest retained byte, which
+// can be used concurrently with writes and other cursors. Its ReadContext
+// method blocks until data is written.
+func (bc *Broadcaster) Subscribe() *Cursor {
+	bc.sb.mu.Lock()
+	defer bc.sb.mu.Unlock()
+	c := bc.sb.b.NewCursor()
+	c.sb = bc.sb
+	return c
+}
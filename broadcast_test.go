@@ -0,0 +1,68 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestBroadcaster(t *testing.T) {
+	bc := circbuf.NewBroadcaster(newTestSyncBuffer(t, 1024))
+	bc.Write([]byte("retained "))
+
+	var expect bytes.Buffer
+	expect.WriteString("retained ")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&expect, "message %d\n", i)
+	}
+
+	subs := []*circbuf.Cursor{bc.Subscribe(), bc.Subscribe()}
+	results := make([][]byte, len(subs))
+	var wg sync.WaitGroup
+	for i, c := range subs {
+		wg.Add(1)
+		go func(i int, c *circbuf.Cursor) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// Readers use different chunk sizes
+			out := make([]byte, 3+i*7)
+			for len(results[i]) < expect.Len() {
+				n, err := c.ReadContext(ctx, out)
+				if err != nil {
+					t.Errorf("err: %v", err)
+					return
+				}
+				results[i] = append(results[i], out[:n]...)
+			}
+		}(i, c)
+	}
+
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(bc, "message %d\n", i)
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, r := range results {
+		if !bytes.Equal(r, expect.Bytes()) {
+			t.Fatalf("subscriber %d got %q", i, r)
+		}
+	}
+}
+
+func TestBroadcaster_ReadContextCancelled(t *testing.T) {
+	bc := circbuf.NewBroadcaster(newTestSyncBuffer(t, 16))
+	c := bc.Subscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.ReadContext(ctx, make([]byte, 4)); err != context.DeadlineExceeded {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -1,6 +1,30 @@
 package circbuf
 
-import "fmt"
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrProtected is returned by writes which would overwrite the bytes
+// protected by Protect.
+var ErrProtected = errors.New("circbuf: write would overwrite protected data")
+
+// ErrWriteClosed is returned when writing to a buffer after CloseWrite was
+// called.
+var ErrWriteClosed = errors.New("circbuf: write to a closed buffer")
+
+// ErrMaxWrite is returned when writing more bytes at once than allowed by
+// SetMaxWrite.
+var ErrMaxWrite = errors.New("circbuf: write exceeds the maximum write size")
 
 // Buffer implements a circular buffer. It is a fixed size,
 // and new writes overwrite older data, such that for a buffer
@@ -12,28 +36,304 @@ type Buffer struct {
 	size        int64
 	writeCursor int64
 	readCursor  int64
-	written     int64
-	offset      int64
+	// written only grows up to twice the size, enough to know if the
+	// ring wrapped, total holds the total number of bytes written since
+	// the last reset.
+	written int64
+	total   int64
+	// discarded counts the bytes written before the last reset when
+	// TotalWritten is cumulative.
+	cumulative bool
+	discarded  int64
+	// offset is set when the buffer is created and never changes,
+	// the bytes it covers are never touched by the buffer, unless
+	// persistHeader is set.
+	offset        int64
+	persistHeader bool
+	// headerCursors is the position of the write position in the header
+	headerCursors int
+	// unread is the amount of retained bytes not read yet, gap the
+	// amount of unread bytes overwritten since the last ReadWithGap.
+	unread     int64
+	gap        int64
+	eofOnDrain bool
+	// dirty is set when the ring was modified since the last MarkSynced
+	dirty bool
+	// lastCursor and lastStored locate the bytes stored by the last write
+	lastCursor int64
+	lastStored int64
+	// flush is called every flushEvery written bytes
+	flush      func() error
+	flushEvery int64
+	unflushed  int64
+	// maxWrite is the largest write accepted, 0 means no limit
+	maxWrite int
+	// lineAligned buffers drop the partial line left at the start of
+	// the retained data by an overwrite.
+	lineAligned bool
+	// protected bytes, starting at the protectSeq sequence number, can't
+	// be overwritten.
+	protected  int64
+	protectSeq int64
+	// preamble replaces the oldest retained bytes once the ring wrapped
+	preamble []byte
+	// writeClosed is set once no more data will be written
+	writeClosed bool
+	// next describes the slice last returned by NextWriteSlice, it can
+	// be committed as long as the write cursor and total didn't change.
+	nextLen    int64
+	nextCursor int64
+	nextTotal  int64
+	// owned is set when the buffer allocated its backing slice, it can
+	// then grow up to maxSize.
+	owned   bool
+	maxSize int64
+	// wraps counts the times the write cursor went back to the start
+	// of the ring, wrapHook is called each time.
+	wraps    int64
+	wrapHook func(wrapCount int64)
 }
 
 // NewBuffer sets a new circular buffer on top of the passed slice of bytes.
 // A certain amount of bytes can be skipped if used as flags for instance and
 // the length of the buffer must also be set.
 func NewBuffer(m []byte, skip, size int64) (*Buffer, error) {
-	b := &Buffer{
+	b := &Buffer{}
+	if err := b.ResetWith(m, skip, size); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewBufferLineAligned sets a new circular buffer on top of the passed slice
+// of bytes, like NewBuffer does, for line oriented data. Once older data is
+// overwritten, the retained data starts after the first line feed found so
+// it never starts with a partial line. The retained data can be empty if
+// it holds a single partial line.
+func NewBufferLineAligned(m []byte, skip, size int64) (*Buffer, error) {
+	b, err := NewBuffer(m, skip, size)
+	if err != nil {
+		return nil, err
+	}
+	b.lineAligned = true
+	return b, nil
+}
+
+// NewSizedBuffer allocates a new circular buffer of the passed size, without
+// offset. Unlike buffers set on top of a provided slice, it can grow, see
+// SetGrowable.
+func NewSizedBuffer(size int64) (*Buffer, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("circbuf: invalid size %d", size)
+	}
+	b, err := NewBuffer(make([]byte, size), 0, size)
+	if err != nil {
+		return nil, err
+	}
+	b.owned = true
+	return b, nil
+}
+
+// NewBufferFull sets a new circular buffer on top of the passed slice of
+// bytes, using all the bytes found after skipping the passed amount of bytes.
+func NewBufferFull(m []byte, skip int64) (*Buffer, error) {
+	return NewBuffer(m, skip, int64(len(m))-skip)
+}
+
+// NewPageAlignedBuffer sets a new circular buffer on top of the passed slice
+// of bytes, like NewBuffer does, with its size rounded down to a multiple of
+// the memory page size. An error is returned if size is smaller than a page.
+func NewPageAlignedBuffer(m []byte, skip, size int64) (*Buffer, error) {
+	page := int64(os.Getpagesize())
+	if aligned := size - size%page; aligned > 0 {
+		return NewBuffer(m, skip, aligned)
+	}
+	return nil, fmt.Errorf("circbuf: size %d smaller than a %d bytes page", size, page)
+}
+
+// NewBufferBounded sets a new circular buffer on the size bytes of m found
+// after skipping the passed amount of bytes. Unlike NewBuffer, the buffer
+// only holds the ring region of m, and has no offset, so writes can never
+// touch the bytes before or after it.
+func NewBufferBounded(m []byte, skip, size int64) (*Buffer, error) {
+	if err := checkLayout(m, skip, size); err != nil {
+		return nil, err
+	}
+	if skip+size > int64(len(m)) {
+		return nil, fmt.Errorf("circbuf: %d bytes at offset %d don't fit in a %d bytes slice", size, skip, len(m))
+	}
+	return NewBuffer(m[skip:skip+size:skip+size], 0, size)
+}
+
+// Split lays out several independent circular buffers back to back on top of
+// the passed slice of bytes, after skipping the passed amount of bytes. The
+// i-th buffer has a size of sizes[i] and starts right after the previous one.
+// Each buffer only sees the slice up to the end of its ring so writes never
+// touch the next ring.
+func Split(m []byte, skip int64, sizes ...int64) ([]*Buffer, error) {
+	total := skip
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("circbuf: invalid size %d", size)
+		}
+		total += size
+	}
+	if skip < 0 || total > int64(len(m)) {
+		return nil, fmt.Errorf("circbuf: %d bytes at offset %d don't fit in a %d bytes slice", total-skip, skip, len(m))
+	}
+
+	bufs := make([]*Buffer, len(sizes))
+	offset := skip
+	for i, size := range sizes {
+		end := offset + size
+		b, err := NewBuffer(m[:end:end], offset, size)
+		if err != nil {
+			return nil, err
+		}
+		bufs[i] = b
+		offset = end
+	}
+	return bufs, nil
+}
+
+// NewBufferFromExisting sets a new circular buffer on top of the passed slice
+// of bytes which already holds ring data, for instance a memory mapped file
+// reopened after a restart. The write cursor and the total number of bytes
+// written, usually persisted in the offset region, restore the logical state
+// of the ring. All the retained data is left to be read.
+func NewBufferFromExisting(m []byte, skip, size, writeCursor, written int64) (*Buffer, error) {
+	b, err := NewBuffer(m, skip, size)
+	if err != nil {
+		return nil, err
+	}
+	b.writeCursor = writeCursor
+	b.written = written
+	b.total = written
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	b.normalize()
+	b.unread = b.Len()
+	if written >= size {
+		b.readCursor = writeCursor
+	}
+	return b, nil
+}
+
+// ResetWith resets the buffer and sets it on top of a new slice of bytes,
+// as if it was created by NewBuffer, so a Buffer can be recycled.
+// On error, the buffer is left untouched.
+func (b *Buffer) ResetWith(m []byte, skip, size int64) error {
+	if err := checkLayout(m, skip, size); err != nil {
+		return err
+	}
+	*b = Buffer{
 		offset: skip,
 		size:   size,
 		data:   m,
 	}
+	return nil
+}
+
+// SwapBacking copies the ring into newData, at the same offset, and moves
+// the buffer on top of it, for instance after remapping a grown file. The
+// bytes before the offset aren't copied, except the persisted header, see
+// EnableHeaderPersistence. newData must be able to hold the ring, on error
+// the buffer is left untouched.
+func (b *Buffer) SwapBacking(newData []byte) error {
+	if b.offset+b.size > int64(len(newData)) {
+		return fmt.Errorf("circbuf: %d bytes at offset %d don't fit in a %d bytes slice", b.size, b.offset, len(newData))
+	}
+	copy(newData[b.offset:b.offset+b.size], b.data[b.offset:])
+	if b.persistHeader {
+		// the magic, and version, found before the cursors
+		copy(newData, b.data[:b.headerCursors])
+	}
+	b.data = newData
+	b.dirty = true
+	b.writeHeader()
+	return nil
+}
+
+// Validate checks the invariants of the buffer state, which can be broken
+// when the state is restored from corrupt persisted data, and returns an
+// error listing all the violations found.
+func (b *Buffer) Validate() error {
+	var problems []string
+	if b.size <= 0 {
+		problems = append(problems, fmt.Sprintf("invalid size %d", b.size))
+	}
+	if b.offset < 0 || b.offset+b.size > int64(len(b.data)) {
+		problems = append(problems, fmt.Sprintf("%d bytes at offset %d don't fit in a %d bytes slice", b.size, b.offset, len(b.data)))
+	}
+	if b.writeCursor < 0 || b.writeCursor >= b.size {
+		problems = append(problems, fmt.Sprintf("write cursor %d out of a %d bytes ring", b.writeCursor, b.size))
+	}
+	// Read leaves the read cursor at the end of the ring
+	// before looping back to its beginning.
+	if b.readCursor < 0 || b.readCursor > b.size {
+		problems = append(problems, fmt.Sprintf("read cursor %d out of a %d bytes ring", b.readCursor, b.size))
+	}
+	if b.written < 0 {
+		problems = append(problems, fmt.Sprintf("negative written count %d", b.written))
+	} else if b.written < b.size && b.writeCursor != b.written {
+		problems = append(problems, fmt.Sprintf("write cursor %d doesn't match the %d bytes written", b.writeCursor, b.written))
+	}
+	if b.unread < 0 || b.unread > b.Len() {
+		problems = append(problems, fmt.Sprintf("%d unread bytes out of %d retained", b.unread, b.Len()))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("circbuf: invalid state: %s", strings.Join(problems, ", "))
+}
+
+// checkLayout verifies the offset and size of a ring set on top of m.
+// The end of the ring isn't checked against the length of m, writes
+// going past the end of m panic.
+func checkLayout(m []byte, skip, size int64) error {
+	switch {
+	case skip < 0 || skip > int64(len(m)):
+		return fmt.Errorf("circbuf: offset %d out of a %d bytes slice", skip, len(m))
+	case size <= 0:
+		return fmt.Errorf("circbuf: invalid size %d", size)
+	}
+	return nil
+}
+
+// CopyBuffer sets a new circular buffer on top of the passed slice of bytes,
+// like NewBuffer does, and seeds it with the bytes retained by src, in
+// logical order. The new buffer doesn't need to have the same size or
+// offset as src, if it is smaller only the newest bytes are kept.
+func CopyBuffer(src *Buffer, dst []byte, skip, size int64) (*Buffer, error) {
+	b, err := NewBuffer(dst, skip, size)
+	if err != nil {
+		return nil, err
+	}
+	tail, head := src.segments()
+	b.Write(tail)
+	b.Write(head)
 	return b, nil
 }
 
 // Write writes up to len(buf) bytes to the internal ring,
 // overriding older data if necessary.
 func (b *Buffer) Write(buf []byte) (int, error) {
-	// Account for total bytes written
 	n := len(buf)
-	b.written += int64(n)
+	if n == 0 {
+		return 0, nil
+	}
+	if b.writeClosed {
+		return 0, ErrWriteClosed
+	}
+	if b.maxWrite > 0 && n > b.maxWrite {
+		return 0, ErrMaxWrite
+	}
+	// A growable buffer can make room for the write first
+	b.grow(int64(n))
+	if int64(n) > b.writable() {
+		return 0, ErrProtected
+	}
 
 	// If the buffer is larger than ours, then we only care
 	// about the last size bytes anyways
@@ -41,16 +341,528 @@ func (b *Buffer) Write(buf []byte) (int, error) {
 		buf = buf[int64(n)-b.size:]
 	}
 
-	// Copy in place
-	remain := b.size - b.writeCursor
-	copy(b.data[b.offset+b.writeCursor:], buf)
-	if int64(len(buf)) > remain {
-		copy(b.data[b.offset:], buf[remain:])
+	// Copy in place, without going past the end of the ring
+	ring := b.data[b.offset:]
+	if int64(len(ring)) > b.size {
+		ring = ring[:b.size]
+	}
+	k := copy(ring[b.writeCursor:], buf)
+	copy(ring, buf[k:])
+
+	return n, b.advance(int64(n), int64(len(buf)))
+}
+
+// WriteV writes the passed slices, in order, as a single write without
+// concatenating them. Only the last size bytes of all the slices are
+// copied to the ring.
+func (b *Buffer) WriteV(bufs ...[]byte) (int, error) {
+	var n int
+	for _, p := range bufs {
+		n += len(p)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if b.writeClosed {
+		return 0, ErrWriteClosed
+	}
+	if b.maxWrite > 0 && n > b.maxWrite {
+		return 0, ErrMaxWrite
+	}
+	b.grow(int64(n))
+	if int64(n) > b.writable() {
+		return 0, ErrProtected
+	}
+
+	ring := b.data[b.offset:]
+	if int64(len(ring)) > b.size {
+		ring = ring[:b.size]
+	}
+	skip := int64(n) - b.size
+	var stored int64
+	for _, p := range bufs {
+		if skip > 0 {
+			if int64(len(p)) <= skip {
+				skip -= int64(len(p))
+				continue
+			}
+			p = p[skip:]
+			skip = 0
+		}
+		k := copy(ring[(b.writeCursor+stored)%b.size:], p)
+		copy(ring, p[k:])
+		stored += int64(len(p))
+	}
+
+	return n, b.advance(int64(n), stored)
+}
+
+// NextWriteSlice returns the free space of the ring found after the write
+// cursor, up to the end of the ring, so it can be filled in place without
+// copying. The bytes written to it are only accounted for once passed to
+// Commit. It is empty if nothing can be written.
+func (b *Buffer) NextWriteSlice() []byte {
+	if b.writeClosed {
+		return nil
+	}
+	n := b.size - b.writeCursor
+	if room := b.writable(); n > room {
+		n = room
+	}
+	ring := b.data[b.offset:]
+	if int64(len(ring)) < b.writeCursor+n {
+		n = int64(len(ring)) - b.writeCursor
+	}
+	b.nextLen = n
+	b.nextCursor = b.writeCursor
+	b.nextTotal = b.total
+	return ring[b.writeCursor : b.writeCursor+n : b.writeCursor+n]
+}
+
+// Commit accounts for n bytes written in place at the start of the slice
+// returned by NextWriteSlice, as if they were passed to Write. An error is
+// returned if n is larger than the slice or if the buffer was written to,
+// reset, or closed for writing since the slice was returned.
+func (b *Buffer) Commit(n int) error {
+	switch {
+	case n < 0:
+		return fmt.Errorf("circbuf: negative count %d", n)
+	case n == 0:
+		return nil
+	case b.writeClosed:
+		return ErrWriteClosed
+	case b.writeCursor != b.nextCursor || b.total != b.nextTotal:
+		return errors.New("circbuf: commit of a stale write slice")
+	case int64(n) > b.nextLen:
+		return fmt.Errorf("circbuf: commit of %d bytes in a %d bytes write slice", n, b.nextLen)
+	case b.maxWrite > 0 && n > b.maxWrite:
+		return ErrMaxWrite
+	}
+	b.nextLen = 0
+	return b.advance(int64(n), int64(n))
+}
+
+// CloseWrite marks the end of the written data, later writes are rejected
+// with ErrWriteClosed. Blocked readers, like SyncBuffer.ReadContext, then
+// return io.EOF once all the data is read instead of waiting for more.
+// Use SyncBuffer.CloseWrite to wake up the blocked readers.
+func (b *Buffer) CloseWrite() {
+	b.writeClosed = true
+}
+
+// SetMaxWrite limits the amount of bytes accepted by a single write, larger
+// writes are rejected with ErrMaxWrite. It applies to all the write methods,
+// for WriteReaderN to the maximum amount of bytes to read. It is meant to
+// catch callers writing huge slices by mistake. A max of 0 removes the limit.
+func (b *Buffer) SetMaxWrite(max int) {
+	if max < 0 {
+		max = 0
+	}
+	b.maxWrite = max
+}
+
+// WriteBuffer writes the bytes retained by other, oldest first, to the
+// internal ring, overriding older data if necessary. The bytes are written
+// as a single write, see WriteV.
+func (b *Buffer) WriteBuffer(other *Buffer) (int, error) {
+	tail, head := other.segments()
+	return b.WriteV(tail, head)
+}
+
+// Writer returns an io.Writer writing to the buffer which only exposes the
+// Write method, so the buffer can be handed to code which shouldn't read it.
+func (b *Buffer) Writer() io.Writer {
+	return writer{b}
+}
+
+type writer struct {
+	b *Buffer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	return w.b.Write(p)
+}
+
+// NewLogger returns a logger writing to the buffer so the tail of the logs
+// is always retained. See log.New for the meaning of prefix and flag.
+func (b *Buffer) NewLogger(prefix string, flag int) *log.Logger {
+	return log.New(b, prefix, flag)
+}
+
+// GzipWriter returns a gzip writer compressing into the buffer, so the ring
+// retains the last compressed bytes. Once older data got overwritten, the
+// retained data is the end of a gzip stream which can't be decompressed on
+// its own. Close must be called to flush the end of the stream.
+func (b *Buffer) GzipWriter() io.WriteCloser {
+	return gzip.NewWriter(b)
+}
+
+// WriteRune writes the UTF-8 encoding of r to the internal ring and returns
+// the number of bytes written.
+func (b *Buffer) WriteRune(r rune) (int, error) {
+	var p [utf8.UTFMax]byte
+	n := utf8.EncodeRune(p[:], r)
+	return b.Write(p[:n])
+}
+
+// WriteLine writes p followed by a line feed as a single write, so the line
+// is never left without its line feed. It returns the number of bytes
+// written, line feed included.
+func (b *Buffer) WriteLine(p []byte) (int, error) {
+	return b.WriteV(p, newline)
+}
+
+var newline = []byte{'\n'}
+
+// WriteUntilFull writes p like Write does and reports whether this write is
+// the one which filled the buffer, meaning that older writes were only
+// appended to the ring and this one reached its capacity. Later writes
+// overwrite older data and report false.
+func (b *Buffer) WriteUntilFull(p []byte) (written int, full bool, err error) {
+	wasFull := b.written >= b.size
+	written, err = b.Write(p)
+	return written, !wasFull && b.written >= b.size, err
+}
+
+// WriteReaderN reads at most n bytes from r straight into the internal ring,
+// overriding older data if necessary. It returns the number of bytes read
+// and the first error encountered, reaching the end of r isn't considered an
+// error.
+func (b *Buffer) WriteReaderN(r io.Reader, n int64) (int64, error) {
+	if b.writeClosed && n > 0 {
+		return 0, ErrWriteClosed
+	}
+	if b.maxWrite > 0 && n > int64(b.maxWrite) {
+		return 0, ErrMaxWrite
+	}
+	b.grow(n)
+	var total int64
+	for total < n {
+		room := b.writable()
+		if room == 0 {
+			return total, ErrProtected
+		}
+		end := b.writeCursor + n - total
+		if end-b.writeCursor > room {
+			end = b.writeCursor + room
+		}
+		if end > b.size {
+			end = b.size
+		}
+		k, err := r.Read(b.data[b.offset+b.writeCursor : b.offset+end])
+		ferr := b.advance(int64(k), int64(k))
+		total += int64(k)
+		if err == io.EOF {
+			return total, ferr
+		}
+		if err != nil {
+			return total, err
+		}
+		if ferr != nil {
+			return total, ferr
+		}
+	}
+	return total, nil
+}
+
+// WriteSection writes the whole content of sr, from its start whatever its
+// current position is, to the internal ring, overriding older data if
+// necessary. io.ErrUnexpectedEOF is returned if the section is shorter than
+// announced, the underlying file being truncated for instance.
+func (b *Buffer) WriteSection(sr *io.SectionReader) (int64, error) {
+	size := sr.Size()
+	n, err := b.WriteReaderN(io.NewSectionReader(sr, 0, size), size)
+	if err == nil && n < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Fill writes n copies of c to the internal ring, overriding older
+// data if necessary, without allocating.
+func (b *Buffer) Fill(c byte, n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	if b.writeClosed {
+		return 0, ErrWriteClosed
+	}
+	if b.maxWrite > 0 && n > b.maxWrite {
+		return 0, ErrMaxWrite
+	}
+	b.grow(int64(n))
+	if int64(n) > b.writable() {
+		return 0, ErrProtected
+	}
+	stored := int64(n)
+	if stored > b.size {
+		stored = b.size
+	}
+
+	end := b.writeCursor + stored
+	if end > b.size {
+		fill(b.data[b.offset:b.offset+end-b.size], c)
+		end = b.size
+	}
+	fill(b.data[b.offset+b.writeCursor:b.offset+end], c)
+
+	return n, b.advance(int64(n), stored)
+}
+
+// Protect prevents the oldest n retained bytes from being overwritten until
+// Release is called, so a committed region can be kept around. Writes which
+// would evict them are rejected with ErrProtected and write nothing. As the
+// oldest bytes are evicted first, once the ring is full no write goes
+// through. Resetting or truncating the buffer releases the protection.
+func (b *Buffer) Protect(n int64) {
+	if l := b.Len(); n > l {
+		n = l
+	}
+	if n <= 0 {
+		b.Release()
+		return
+	}
+	b.protected = n
+	b.protectSeq = b.OldestSeq()
+}
+
+// Release removes the protection set by Protect.
+func (b *Buffer) Release() {
+	b.protected = 0
+	b.protectSeq = 0
+}
+
+// writable returns how many bytes can be written without overwriting the
+// protected bytes.
+func (b *Buffer) writable() int64 {
+	if b.protected == 0 {
+		return math.MaxInt64
+	}
+	return b.size - (b.total - b.protectSeq)
+}
+
+// SetGrowable lets a buffer created by NewSizedBuffer grow instead of
+// overwriting older data: writes which don't fit double the size of the
+// ring, reallocating it, up to maxSize bytes. Once the ring reached maxSize
+// bytes, older data is overwritten. A maxSize of 0 disables the growth.
+func (b *Buffer) SetGrowable(maxSize int64) error {
+	switch {
+	case !b.owned:
+		return errors.New("circbuf: only buffers created by NewSizedBuffer can grow")
+	case maxSize != 0 && maxSize < b.size:
+		return fmt.Errorf("circbuf: maximum size %d smaller than the %d bytes ring", maxSize, b.size)
+	}
+	b.maxSize = maxSize
+	return nil
+}
+
+// grow reallocates the ring of a growable buffer, doubling its size up to
+// its maximum size, until n more bytes fit without overwriting older data.
+// The retained bytes are moved to the start of the new ring.
+func (b *Buffer) grow(n int64) {
+	l := b.Len()
+	if b.maxSize <= b.size || l+n <= b.size {
+		return
+	}
+	size := b.size
+	for size < l+n && size < b.maxSize {
+		size *= 2
+	}
+	if size > b.maxSize {
+		size = b.maxSize
+	}
+
+	data := make([]byte, size)
+	b.copyAt(data, 0)
+	read := l - b.unread
+	b.data = data
+	b.size = size
+	b.writeCursor = l % size
+	b.readCursor = read % size
+	b.written = l
+	b.dirty = true
+}
+
+// advance accounts for n bytes written of which the last stored ones
+// were copied to the ring, starting at the write cursor. It returns the
+// error of the flusher, if it had to be called.
+func (b *Buffer) advance(n, stored int64) error {
+	if stored > 0 {
+		b.dirty = true
+	}
+
+	// Account for total bytes written
+	b.written += n
+	b.normalize()
+	if b.total > math.MaxInt64-n {
+		b.total = math.MaxInt64
+	} else {
+		b.total += n
 	}
 
 	// Update location of the cursor
-	b.writeCursor = ((b.writeCursor + int64(len(buf))) % b.size)
-	return n, nil
+	b.lastCursor = b.writeCursor
+	b.lastStored = stored
+	wrapped := stored > 0 && b.writeCursor+stored >= b.size
+	b.writeCursor = ((b.writeCursor + stored) % b.size)
+	if len(b.preamble) > 0 && b.total > b.size {
+		b.writePreamble()
+		// The preamble is stored right after the written bytes
+		b.lastStored += int64(len(b.preamble))
+		if b.lastStored > b.size {
+			b.lastStored = b.size
+		}
+	}
+
+	b.unread += n
+	b.clampUnread()
+	b.writeHeader()
+
+	if wrapped {
+		b.wraps++
+		if b.wrapHook != nil {
+			b.wrapHook(b.wraps)
+		}
+	}
+
+	if b.flush != nil {
+		b.unflushed += n
+		if b.unflushed >= b.flushEvery {
+			b.unflushed = 0
+			return b.flush()
+		}
+	}
+	return nil
+}
+
+// SetWrapPreamble sets bytes, a schema or magic line for instance, which
+// replace the oldest retained bytes once older data got overwritten so the
+// retained data always starts with them. The ring then behaves as if it had
+// len(p) bytes less for the written data. The preamble is copied. An error
+// is returned if it doesn't leave room for data, a nil preamble removes it.
+func (b *Buffer) SetWrapPreamble(p []byte) error {
+	if int64(len(p)) >= b.size {
+		return fmt.Errorf("circbuf: %d bytes preamble too large for a %d bytes ring", len(p), b.size)
+	}
+	b.preamble = append([]byte(nil), p...)
+	if len(p) > 0 && b.total > b.size {
+		b.writePreamble()
+		b.lastCursor = b.writeCursor
+		b.lastStored = int64(len(p))
+		b.clampUnread()
+	}
+	return nil
+}
+
+// writePreamble copies the preamble over the oldest retained bytes.
+func (b *Buffer) writePreamble() {
+	ring := b.data[b.offset : b.offset+b.size]
+	n := copy(ring[b.writeCursor:], b.preamble)
+	copy(ring, b.preamble[n:])
+	b.dirty = true
+}
+
+// clampUnread drops the unread bytes which got overwritten, by newer data or
+// the preamble, so the next read starts at the oldest unread byte left.
+func (b *Buffer) clampUnread() {
+	max := b.size
+	if len(b.preamble) > 0 && b.total > b.size {
+		max -= int64(len(b.preamble))
+	}
+	if b.lineAligned {
+		if l := b.Len(); l < max {
+			max = l
+		}
+	}
+	if b.unread > max {
+		b.gap += b.unread - max
+		b.unread = max
+	}
+	// The default Read loops over the ring from its own position
+	if b.eofOnDrain {
+		b.readCursor = b.readPos()
+	}
+}
+
+// normalize keeps written from growing past twice the size. Once the ring
+// wrapped, the amount written doesn't matter to locate the retained data.
+func (b *Buffer) normalize() {
+	if b.written >= 2*b.size {
+		b.written = b.size + b.written%b.size
+	}
+}
+
+// SetWrapHook sets a function called by Write, and the other write methods,
+// each time the write cursor reaches the end of the ring and goes back to
+// its start, with the number of times it happened since the buffer was
+// created. It can be used to track how often the ring wraps. A nil
+// function removes the hook.
+func (b *Buffer) SetWrapHook(fn func(wrapCount int64)) {
+	b.wrapHook = fn
+}
+
+// SetFlusher sets a function called by Write, and the other write methods,
+// once everyNBytes bytes were written since it was last called. A memory
+// mapped buffer can use it to flush the mapped memory regularly. The flusher
+// error is returned by the write method which called it.
+// A nil function removes the flusher.
+func (b *Buffer) SetFlusher(fn func() error, everyNBytes int64) {
+	b.flush = fn
+	b.flushEvery = everyNBytes
+	b.unflushed = 0
+}
+
+// fill sets all the bytes of s to c, doubling the copied region at
+// each pass.
+func fill(s []byte, c byte) {
+	if len(s) == 0 {
+		return
+	}
+	s[0] = c
+	for i := 1; i < len(s); i *= 2 {
+		copy(s[i:], s[:i])
+	}
+}
+
+// Offset returns the number of bytes skipped at the start of the backing
+// slice, before the ring.
+func (b *Buffer) Offset() int64 {
+	return b.offset
+}
+
+// Header returns the bytes skipped at the start of the backing slice,
+// before the ring, where callers can store flags for instance.
+func (b *Buffer) Header() []byte {
+	return b.data[:b.offset:b.offset]
+}
+
+// Trailer returns the bytes found after the ring in the backing slice, where
+// callers can store a footer or a checksum for instance.
+func (b *Buffer) Trailer() []byte {
+	end := b.offset + b.size
+	if end >= int64(len(b.data)) {
+		return nil
+	}
+	return b.data[end:]
+}
+
+// WriteTrailer copies p at the start of the trailer, see Trailer. An error
+// is returned, and nothing is written, if p doesn't fit in the trailer.
+func (b *Buffer) WriteTrailer(p []byte) error {
+	trailer := b.Trailer()
+	if len(p) > len(trailer) {
+		return fmt.Errorf("circbuf: %d bytes don't fit in a %d bytes trailer", len(p), len(trailer))
+	}
+	copy(trailer, p)
+	b.dirty = true
+	return nil
+}
+
+// Backing returns the slice the buffer was set on, offset included, to
+// inspect it or compute file positions. It should be read only, writing to
+// the ring region bypasses the buffer bookkeeping.
+func (b *Buffer) Backing() []byte {
+	return b.data
 }
 
 // Size returns the size of the buffer
@@ -58,29 +870,151 @@ func (b *Buffer) Size() int64 {
 	return b.size
 }
 
-// TotalWritten provides the total number of bytes written
-func (b *Buffer) TotalWritten() int64 {
+// LastDirtyRange returns the range of indexes, in the backing slice, of
+// the bytes stored by the most recent write, along with the preamble copied
+// after them, see SetWrapPreamble, so a memory mapped buffer can flush only
+// the modified pages. If the write wrapped around the end of the
+// ring, two ranges were modified: from start to the end of the ring and
+// from the start of the ring to end. The range is empty if nothing was
+// stored.
+func (b *Buffer) LastDirtyRange() (start, end int64, wrapped bool) {
+	start = b.offset + b.lastCursor
+	end = b.lastCursor + b.lastStored
+	if end > b.size {
+		return start, b.offset + end - b.size, true
+	}
+	return start, b.offset + end, false
+}
+
+// Cap returns the amount of bytes available for the ring in the backing
+// slice, after the offset. It is larger than Size when the ring doesn't use
+// all the available space and smaller when the slice is too short, which
+// points to a misconfigured memory mapped region.
+func (b *Buffer) Cap() int64 {
+	return int64(len(b.data)) - b.offset
+}
+
+// PercentFull returns the share of the ring holding retained data, from 0
+// to 100, for metrics.
+func (b *Buffer) PercentFull() float64 {
+	if b.size <= 0 {
+		return 0
+	}
+	p := float64(b.Len()) / float64(b.size) * 100
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// Dirty reports whether the ring was modified since the buffer was created
+// or MarkSynced was last called. It is meant to know when a memory mapped
+// file needs to be synced.
+func (b *Buffer) Dirty() bool {
+	return b.dirty
+}
+
+// MarkSynced clears the dirty flag, it should be called after the backing
+// memory was synced.
+func (b *Buffer) MarkSynced() {
+	b.dirty = false
+}
+
+// Len returns the number of bytes retained by the buffer.
+func (b *Buffer) Len() int64 {
+	if b.lineAligned {
+		tail, head := b.segments()
+		return int64(len(tail) + len(head))
+	}
+	if b.written > b.size {
+		return b.size
+	}
 	return b.written
 }
 
+// TotalWritten provides the total number of bytes written.
+// It saturates at math.MaxInt64.
+// Resetting the buffer restarts the count, see SetCumulativeTotal.
+func (b *Buffer) TotalWritten() int64 {
+	if b.discarded > math.MaxInt64-b.total {
+		return math.MaxInt64
+	}
+	return b.discarded + b.total
+}
+
+// SetCumulativeTotal sets whether TotalWritten keeps counting all the bytes
+// ever written across Reset, ResetKeep and Truncate. By default, the count
+// restarts from the amount of bytes kept. Sequence numbers, see OldestSeq,
+// always restart. ResetWith clears the setting.
+func (b *Buffer) SetCumulativeTotal(on bool) {
+	b.cumulative = on
+	if !on {
+		b.discarded = 0
+	}
+}
+
+// restart sets the total number of bytes written after a reset which kept
+// n bytes. The other bytes are accounted as discarded in cumulative mode.
+func (b *Buffer) restart(n int64) {
+	if b.cumulative {
+		if d := b.total - n; b.discarded > math.MaxInt64-d {
+			b.discarded = math.MaxInt64
+		} else {
+			b.discarded += d
+		}
+	}
+	b.total = n
+}
+
+// Overwritten returns the number of written bytes which were evicted by
+// newer writes.
+func (b *Buffer) Overwritten() int64 {
+	return b.total - b.Len()
+}
+
+// OldestSeq returns the sequence number of the oldest retained byte, that is
+// its index in the stream of all the bytes ever written to the buffer.
+func (b *Buffer) OldestSeq() int64 {
+	return b.total - b.Len()
+}
+
+// SeqAt returns the sequence number of the byte found at the passed logical
+// position, 0 being the oldest retained byte, or -1 if the position is out
+// of the retained data.
+func (b *Buffer) SeqAt(logical int64) int64 {
+	if logical < 0 || logical >= b.Len() {
+		return -1
+	}
+	return b.OldestSeq() + logical
+}
+
+// HasSeq reports whether the byte with the passed sequence number is still
+// retained, so a consumer tracking sequence numbers can tell it fell behind
+// before reading.
+func (b *Buffer) HasSeq(seq int64) bool {
+	return seq >= b.OldestSeq() && seq < b.total
+}
+
 // Read reads up to len(p) bytes into p. It returns the number of bytes read (0
 // <= n <= len(p)) and any error encountered. Even if Read returns n < len(p),
 // it may use all of p as scratch space during the call. If some data is
 // available but not len(p) bytes, Read conventionally returns what is available
 // instead of waiting for more.
+//
+// By default Read loops over the ring and never runs out of data, see
+// SetEOFOnDrain for a Read that stops once the retained data was read.
 func (b *Buffer) Read(out []byte) (n int, err error) {
+	if b.eofOnDrain {
+		return b.drain(out)
+	}
 	if b.readCursor >= b.Size() {
 		// we read the entire buffer, let's loop back to the beginning
 		b.readCursor = 0
 	} else if b.readCursor+int64(len(out)) > b.Size() {
 		// we don't have enough data in our buffer to fill the passed buffer
 		// we need to do multiple passes
-		n := copy(out, b.data[b.offset+b.readCursor:])
+		n := copy(out, b.data[b.offset+b.readCursor:b.offset+b.size])
 		b.readCursor += int64(n)
-		// TMP check, should remove
-		if b.readCursor != b.Size() {
-			panic(fmt.Sprintf("off by one much? %d - %d", b.readCursor, b.Size()))
-		}
 		n2, _ := b.Read(out[n:])
 		b.readCursor += int64(n2)
 		return int(n + n2), nil
@@ -89,26 +1023,702 @@ func (b *Buffer) Read(out []byte) (n int, err error) {
 	return
 }
 
+// SetEOFOnDrain changes the behavior of Read so it goes through the retained
+// data, oldest byte first, and returns io.EOF once everything was read,
+// instead of looping over the ring. Data written later on can then be read.
+// Turning the mode on rewinds the read position to the oldest retained byte.
+func (b *Buffer) SetEOFOnDrain(on bool) {
+	b.eofOnDrain = on
+	if on {
+		b.Seek(0, io.SeekStart)
+	}
+}
+
+// Seek sets the read position within the retained data, 0 being the oldest
+// retained byte, and returns the new position. It is meant to be used with
+// SetEOFOnDrain.
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.Len() - b.unread
+	case io.SeekEnd:
+		offset += b.Len()
+	default:
+		return 0, errWhence
+	}
+	if offset < 0 || offset > b.Len() {
+		return 0, errSeekRange
+	}
+	b.readCursor = (b.start() + offset) % b.size
+	b.unread = b.Len() - offset
+	return offset, nil
+}
+
+// SeekToOldest moves the read position back to the oldest retained byte so
+// the retained data can be read again. The written data is left untouched.
+func (b *Buffer) SeekToOldest() {
+	b.Seek(0, io.SeekStart)
+}
+
+// ReadN reads exactly n unread bytes, oldest first, and returns a copy of
+// them. If fewer bytes are available, they are read and returned along with
+// io.ErrUnexpectedEOF.
+func (b *Buffer) ReadN(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("circbuf: negative count %d", n)
+	}
+	var err error
+	if int64(n) > b.unread {
+		n = int(b.unread)
+		err = io.ErrUnexpectedEOF
+	}
+	out := make([]byte, n)
+	b.peek(out, 0)
+	b.consume(int64(n))
+	return out, err
+}
+
+// ReadV reads unread bytes, oldest first, into the passed slices in order,
+// each one being filled before moving to the next, and moves the read
+// position forward. It returns the number of bytes read, io.EOF is returned
+// if nothing was unread.
+func (b *Buffer) ReadV(bufs ...[]byte) (int, error) {
+	var want int
+	for _, p := range bufs {
+		want += len(p)
+	}
+	if want == 0 {
+		return 0, nil
+	}
+	if b.unread == 0 {
+		return 0, io.EOF
+	}
+
+	var n int
+	for _, p := range bufs {
+		k := b.peek(p, 0)
+		b.consume(int64(k))
+		n += k
+		if k < len(p) {
+			break
+		}
+	}
+	return n, nil
+}
+
+// ReadWithGap reads up to len(out) unread bytes, oldest first, into out
+// like Read does once SetEOFOnDrain is on, and also returns the number of
+// unread bytes which were overwritten since the last call, so a consumer
+// can tell it missed data.
+func (b *Buffer) ReadWithGap(out []byte) (n int, gap int64, err error) {
+	gap = b.gap
+	b.gap = 0
+	n, err = b.drain(out)
+	return n, gap, err
+}
+
+// ReadFull reads exactly len(out) unread bytes, oldest first, into out and
+// moves the read position forward, like io.ReadFull does. The error is
+// io.EOF only if no bytes were unread. If fewer than len(out) bytes are
+// unread, they are read and io.ErrUnexpectedEOF is returned.
+func (b *Buffer) ReadFull(out []byte) (int, error) {
+	if len(out) == 0 {
+		return 0, nil
+	}
+	if b.unread == 0 {
+		return 0, io.EOF
+	}
+	n := b.peek(out, 0)
+	b.consume(int64(n))
+	if n < len(out) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// WriteToN writes at most n unread bytes, oldest first, to w and moves the
+// read position forward by the amount written. It returns the number of
+// bytes written and the first error encountered.
+func (b *Buffer) WriteToN(w io.Writer, n int64) (int64, error) {
+	var total int64
+	for total < n && b.unread > 0 {
+		start := b.readPos()
+		end := start + b.unread
+		if end > b.size {
+			end = b.size
+		}
+		if end-start > n-total {
+			end = start + n - total
+		}
+		k, err := w.Write(b.data[b.offset+start : b.offset+end])
+		b.consume(int64(k))
+		total += int64(k)
+		if err != nil {
+			return total, err
+		}
+		if int64(k) < end-start {
+			return total, io.ErrShortWrite
+		}
+	}
+	return total, nil
+}
+
+// CopyN copies at most n unread bytes, oldest first, from src to dst and
+// moves the read position of src forward by the amount copied, like
+// io.CopyN does. Bytes go through the Write method of dst, so older data of
+// dst is overwritten as needed. On return, written == n if and only if err
+// == nil, if src has fewer than n unread bytes io.EOF is returned.
+func CopyN(dst, src *Buffer, n int64) (written int64, err error) {
+	if dst == src {
+		return 0, errors.New("circbuf: CopyN to the source buffer")
+	}
+	written, err = src.WriteToN(dst, n)
+	if err == nil && written < n {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// drain reads unread data in logical order.
+func (b *Buffer) drain(out []byte) (n int, err error) {
+	if b.unread == 0 {
+		if len(out) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n = b.peek(out, 0)
+	b.consume(int64(n))
+	return n, nil
+}
+
+// peek copies the unread data found skip bytes after the read position
+// into out, without consuming it, and returns the number of bytes copied.
+func (b *Buffer) peek(out []byte, skip int64) int {
+	if skip >= b.unread {
+		return 0
+	}
+	if avail := b.unread - skip; int64(len(out)) > avail {
+		out = out[:avail]
+	}
+	start := (b.readPos() + skip) % b.size
+	n := copy(out, b.data[b.offset+start:b.offset+b.size])
+	copy(out[n:], b.data[b.offset:])
+	return len(out)
+}
+
+// consume moves the read position n bytes forward.
+func (b *Buffer) consume(n int64) {
+	b.unread -= n
+	b.readCursor = (b.readCursor + n) % b.size
+}
+
+// Unread moves the read position n bytes back so they are read again, like
+// bufio.Reader.UnreadByte does for a single byte. An error is returned if
+// fewer than n read bytes are still retained.
+func (b *Buffer) Unread(n int) error {
+	if n < 0 {
+		return fmt.Errorf("circbuf: negative count %d", n)
+	}
+	if read := b.Len() - b.unread; int64(n) > read {
+		return fmt.Errorf("circbuf: can't unread %d bytes, only %d read bytes retained", n, read)
+	}
+	b.readCursor = (b.readCursor + b.size - int64(n)) % b.size
+	b.unread += int64(n)
+	return nil
+}
+
+// OldestIndex returns the index in the backing slice of the oldest retained
+// byte, where the logical content of the ring starts. It is the offset
+// until the ring wraps.
+func (b *Buffer) OldestIndex() int64 {
+	return b.offset + b.start()
+}
+
+// PhysicalIndex returns the index in the backing slice of the byte at the
+// passed logical position, 0 being the oldest retained byte. The offset
+// and wrapping are accounted for. -1 is returned if the logical position
+// is out of the retained data.
+func (b *Buffer) PhysicalIndex(logical int64) int64 {
+	if logical < 0 || logical >= b.Len() {
+		return -1
+	}
+	return b.offset + (b.start()+logical)%b.size
+}
+
+// IndexByte returns the logical position of the first instance of c in
+// the retained data, or -1 if c isn't present.
+func (b *Buffer) IndexByte(c byte) int64 {
+	tail, head := b.segments()
+	if i := bytes.IndexByte(tail, c); i >= 0 {
+		return int64(i)
+	}
+	if i := bytes.IndexByte(head, c); i >= 0 {
+		return int64(len(tail) + i)
+	}
+	return -1
+}
+
+// Count returns the number of instances of c in the retained data, the
+// number of line feeds tells how many lines are retained for instance.
+func (b *Buffer) Count(c byte) int {
+	tail, head := b.segments()
+	return bytes.Count(tail, []byte{c}) + bytes.Count(head, []byte{c})
+}
+
+// LineCount returns the number of line feeds in the retained data, which is
+// the number of retained lines not counting a trailing partial line.
+func (b *Buffer) LineCount() int {
+	return b.Count('\n')
+}
+
+// CopyTo copies up to len(dst) retained bytes, oldest first, into dst and
+// returns the number of bytes copied. Unlike Bytes, it never allocates.
+func (b *Buffer) CopyTo(dst []byte) int {
+	return b.copyAt(dst, 0)
+}
+
+// AppendTo appends the retained bytes, oldest first, to dst.
+func (b *Buffer) AppendTo(dst *bytes.Buffer) {
+	tail, head := b.segments()
+	dst.Grow(len(tail) + len(head))
+	dst.Write(tail)
+	dst.Write(head)
+}
+
+// NewBytesReader returns a bytes.Reader reading a copy of the retained
+// bytes, oldest first, so later writes don't affect it.
+func (b *Buffer) NewBytesReader() *bytes.Reader {
+	return bytes.NewReader(b.AppendBytes(nil))
+}
+
+// Chunks returns copies of the retained bytes, oldest first, split in
+// chunks of chunkSize bytes, the last one being shorter if needed.
+// It returns nil if chunkSize isn't positive.
+func (b *Buffer) Chunks(chunkSize int) [][]byte {
+	if chunkSize <= 0 {
+		return nil
+	}
+	var chunks [][]byte
+	l := b.Len()
+	for at := int64(0); at < l; at += int64(chunkSize) {
+		n := l - at
+		if n > int64(chunkSize) {
+			n = int64(chunkSize)
+		}
+		chunk := make([]byte, n)
+		b.copyAt(chunk, at)
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// WriteTempFile writes the retained bytes, oldest first, to a new temporary
+// file, see os.CreateTemp for the meaning of dir and pattern, and returns
+// its path. Removing the file is left to the caller, unless an error is
+// returned.
+func (b *Buffer) WriteTempFile(dir, pattern string) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	tail, head := b.segments()
+	if _, err = f.Write(tail); err == nil {
+		_, err = f.Write(head)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// HashInto writes the retained bytes, oldest first, to h so any checksum or
+// digest of the retained data can be computed without copying it.
+func (b *Buffer) HashInto(h hash.Hash) {
+	tail, head := b.segments()
+	h.Write(tail)
+	h.Write(head)
+}
+
+// AppendBytes appends the retained bytes, oldest first, to dst and returns
+// the extended slice. dst is only reallocated if it lacks capacity.
+func (b *Buffer) AppendBytes(dst []byte) []byte {
+	tail, head := b.segments()
+	dst = append(dst, tail...)
+	return append(dst, head...)
+}
+
+// BytesReversed returns a copy of the retained bytes in reverse order, the
+// newest byte first.
+func (b *Buffer) BytesReversed() []byte {
+	out := b.AppendBytes(make([]byte, 0, b.Len()))
+	reverse(out)
+	return out
+}
+
+// Lines returns a copy of the complete retained lines, without their line
+// feed. Unless the buffer is line aligned, the first line is dropped if older
+// data was overwritten since it might have lost its beginning. The last line
+// is dropped if it doesn't end with a line feed yet.
+func (b *Buffer) Lines() [][]byte {
+	data := b.AppendBytes(make([]byte, 0, b.Len()))
+	if b.Overwritten() > 0 && !b.lineAligned {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil
+		}
+		data = data[i+1:]
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil
+	}
+	return bytes.Split(data[:end], []byte{'\n'})
+}
+
+// DrainFilterLines returns the complete retained lines, see Lines, for which
+// keep returns true and resets the buffer. A trailing partial line is kept
+// so it can be completed by later writes.
+func (b *Buffer) DrainFilterLines(keep func(line []byte) bool) [][]byte {
+	lines := b.Lines()
+	kept := lines[:0]
+	for _, l := range lines {
+		if keep(l) {
+			kept = append(kept, l)
+		}
+	}
+
+	partial := b.Len()
+	if i := b.lastIndexByte('\n'); i >= 0 {
+		partial -= i + 1
+	}
+	b.ResetKeep(partial)
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+// lastIndexByte returns the logical position of the last instance of c in
+// the retained data, or -1 if c isn't present.
+func (b *Buffer) lastIndexByte(c byte) int64 {
+	tail, head := b.segments()
+	if i := bytes.LastIndexByte(head, c); i >= 0 {
+		return int64(len(tail) + i)
+	}
+	return int64(bytes.LastIndexByte(tail, c))
+}
+
+// LinesReversed returns a copy of the retained lines, without their line
+// feed, newest line first. The bytes within each line are kept in order.
+func (b *Buffer) LinesReversed() [][]byte {
+	data := b.AppendBytes(make([]byte, 0, b.Len()))
+	if len(data) == 0 {
+		return nil
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'})
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// copyAt copies the retained data found from the passed logical position
+// into out and returns the number of bytes copied.
+func (b *Buffer) copyAt(out []byte, logical int64) int {
+	tail, head := b.segments()
+	if logical < int64(len(tail)) {
+		n := copy(out, tail[logical:])
+		return n + copy(out[n:], head)
+	}
+	return copy(out, head[logical-int64(len(tail)):])
+}
+
+// EqualBytes reports whether the retained data is equal to p, without
+// copying the retained data.
+func (b *Buffer) EqualBytes(p []byte) bool {
+	tail, head := b.segments()
+	if len(p) != len(tail)+len(head) {
+		return false
+	}
+	return bytes.Equal(tail, p[:len(tail)]) && bytes.Equal(head, p[len(tail):])
+}
+
+// CommonSuffixLen returns the length of the longest common suffix of the
+// data retained by b and other, compared from the newest byte backward
+// without copying, to deduplicate overlapping tails for instance.
+func (b *Buffer) CommonSuffixLen(other *Buffer) int64 {
+	bTail, bHead := b.segments()
+	oTail, oHead := other.segments()
+	i := int64(len(bTail) + len(bHead))
+	j := int64(len(oTail) + len(oHead))
+	var n int64
+	for i > n && j > n {
+		if byteAt(bTail, bHead, i-n-1) != byteAt(oTail, oHead, j-n-1) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// byteAt returns the byte found at the logical position i of the data
+// made of tail followed by head.
+func byteAt(tail, head []byte, i int64) byte {
+	if i < int64(len(tail)) {
+		return tail[i]
+	}
+	return head[i-int64(len(tail))]
+}
+
+// Contains reports whether sub is within the retained data, including
+// across the wrap boundary, without copying the retained data.
+func (b *Buffer) Contains(sub []byte) bool {
+	tail, head := b.segments()
+	if bytes.Contains(tail, sub) || bytes.Contains(head, sub) {
+		return true
+	}
+	// Look for a match starting in tail and ending in head
+	for k := 1; k < len(sub); k++ {
+		if bytes.HasSuffix(tail, sub[:k]) && bytes.HasPrefix(head, sub[k:]) {
+			return true
+		}
+	}
+	return false
+}
+
 // Bytes provides a slice of the bytes written. This
 // slice should not be written to.
 func (b *Buffer) Bytes() []byte {
-	switch {
-	case b.written >= b.size && b.writeCursor == 0:
-		return b.data[b.offset:]
-	case b.written > b.size:
-		out := make([]byte, b.size)
-		copy(out,
-			b.data[b.offset+b.writeCursor:])
-		copy(out[b.size-b.writeCursor:],
-			b.data[b.offset:b.offset+b.writeCursor])
-		return out
-	default:
-		return b.data[b.offset : b.offset+b.writeCursor]
+	tail, head := b.segments()
+	if len(head) == 0 {
+		return tail
 	}
+	out := make([]byte, len(tail)+len(head))
+	copy(out, tail)
+	copy(out[len(tail):], head)
+	return out
+}
+
+// IsContiguous reports whether the retained bytes are contiguous in the
+// ring, in which case Bytes returns them without copying.
+func (b *Buffer) IsContiguous() bool {
+	_, head := b.segments()
+	return len(head) == 0
 }
 
 // Reset resets the buffer so it has no content.
 func (b *Buffer) Reset() {
 	b.writeCursor = 0
 	b.written = 0
+	b.restart(0)
+	b.unread = 0
+	b.gap = 0
+	b.Release()
+	b.writeHeader()
+}
+
+// Zero overwrites the whole ring with zeroes and resets the buffer, so
+// sensitive data doesn't linger in memory or in a memory mapped file.
+// The bytes before and after the ring are left untouched.
+func (b *Buffer) Zero() {
+	ring := b.data[b.offset:]
+	if int64(len(ring)) > b.size {
+		ring = ring[:b.size]
+	}
+	fill(ring, 0)
+	b.dirty = true
+	b.Reset()
+}
+
+// ResetKeep resets the buffer but keeps the last n bytes written.
+// The kept bytes are moved to the start of the ring, in logical order,
+// and the counters are reset so the buffer looks like only those n
+// bytes were ever written: TotalWritten returns n, unless it is
+// cumulative, and counts the following writes from there.
+// The read position is cleared.
+func (b *Buffer) ResetKeep(n int64) {
+	if n <= 0 {
+		b.Reset()
+		return
+	}
+	retained := b.unwrap()
+	if n > retained {
+		n = retained
+	}
+	if n < retained {
+		copy(b.data[b.offset:], b.data[b.offset+retained-n:b.offset+retained])
+		b.dirty = true
+	}
+
+	b.writeCursor = n % b.size
+	b.readCursor = 0
+	b.written = n
+	b.restart(n)
+	b.unread = n
+	b.Release()
+	b.writeHeader()
+}
+
+// segments returns the retained data as two slices of the backing buffer.
+// Read one after the other, tail then head, they hold the retained bytes in
+// logical order. head is empty unless the ring wrapped.
+func (b *Buffer) segments() (tail, head []byte) {
+	if b.written < b.size {
+		return b.data[b.offset : b.offset+b.writeCursor], nil
+	}
+	tail = b.data[b.offset+b.writeCursor : b.offset+b.size]
+	head = b.data[b.offset : b.offset+b.writeCursor]
+	if !b.lineAligned || b.total <= b.size {
+		return tail, head
+	}
+
+	// Skip the line which lost its beginning
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		return tail[i+1:], head
+	}
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		return head[i+1:], nil
+	}
+	return nil, nil
+}
+
+// start returns the index in the ring of the oldest retained byte.
+func (b *Buffer) start() int64 {
+	return (b.writeCursor + b.size - b.Len()) % b.size
+}
+
+// readPos returns the index in the ring of the oldest unread byte. The
+// unread bytes are always the newest retained ones.
+func (b *Buffer) readPos() int64 {
+	return (b.writeCursor + b.size - b.unread) % b.size
+}
+
+// Truncate discards all but the oldest n retained bytes. The kept bytes are
+// moved to the start of the ring and the counters are adjusted so the buffer
+// looks like only those n bytes were ever written. The read position is
+// kept unless it pointed to discarded data.
+func (b *Buffer) Truncate(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	read := b.Len() - b.unread
+	retained := b.unwrap()
+	if n > retained {
+		n = retained
+	}
+	if read > n {
+		read = n
+	}
+
+	b.writeCursor = n % b.size
+	b.readCursor = read % b.size
+	b.written = n
+	b.restart(n)
+	b.unread = n - read
+	b.Release()
+	b.writeHeader()
+}
+
+// Compact moves the retained bytes, in logical order, to the start of the
+// ring so they are contiguous. The retained data, the read position and the
+// counters are preserved, unless fewer bytes than the ring size are retained
+// while more were written, by a line aligned buffer for instance. The
+// counters are then adjusted like Truncate does.
+func (b *Buffer) Compact() {
+	unread := b.unread
+	retained := b.unwrap()
+	b.writeCursor = retained % b.size
+	b.readCursor = (retained - unread) % b.size
+	b.written = retained
+	if retained < b.size && b.total > retained {
+		b.restart(retained)
+	}
+	b.unread = unread
+	b.writeHeader()
+}
+
+// Finalize compacts the buffer, see Compact, and zeroes the rest of the
+// ring, so a memory mapped file is left with the retained data at the start
+// of the ring and nothing else, before shutting down for instance.
+func (b *Buffer) Finalize() {
+	b.Compact()
+	if l := b.Len(); l < b.size {
+		fill(b.data[b.offset+l:b.offset+b.size], 0)
+		b.dirty = true
+	}
+}
+
+// SetSize changes the size of the ring, within the space available after
+// the offset, see Cap. The newest retained bytes which fit are moved to the
+// start of the ring, in logical order, and so are the unread ones. If they
+// don't fill the new ring while more bytes were written, the counters are
+// adjusted like Truncate does so the buffer looks like only those bytes
+// were ever written. The protection set by Protect is released.
+func (b *Buffer) SetSize(newSize int64) error {
+	switch {
+	case newSize <= 0:
+		return fmt.Errorf("circbuf: invalid size %d", newSize)
+	case newSize > b.Cap():
+		return fmt.Errorf("circbuf: size %d larger than the %d bytes available", newSize, b.Cap())
+	case int64(len(b.preamble)) >= newSize:
+		return fmt.Errorf("circbuf: size %d too small for the %d bytes preamble", newSize, len(b.preamble))
+	}
+
+	unread := b.unread
+	retained := b.unwrap()
+	n := retained
+	if n > newSize {
+		n = newSize
+		copy(b.data[b.offset:], b.data[b.offset+retained-n:b.offset+retained])
+		b.dirty = true
+	}
+	if unread > n {
+		unread = n
+	}
+
+	b.size = newSize
+	b.writeCursor = n % newSize
+	b.readCursor = (n - unread) % newSize
+	b.written = n
+	if n < newSize && b.total > n {
+		b.restart(n)
+	}
+	b.unread = unread
+	b.Release()
+	b.writeHeader()
+	return nil
+}
+
+// unwrap moves the retained bytes, in logical order, to the beginning of the
+// ring and returns how many bytes are retained. The ring is rotated in place.
+// Updating the cursors and counters is left to the caller.
+func (b *Buffer) unwrap() int64 {
+	retained := b.Len()
+	if b.written < b.size {
+		return retained
+	}
+	ring := b.data[b.offset : b.offset+b.size]
+	if b.writeCursor != 0 {
+		reverse(ring[:b.writeCursor])
+		reverse(ring[b.writeCursor:])
+		reverse(ring)
+		b.dirty = true
+	}
+	if retained < b.size {
+		copy(ring, ring[b.size-retained:])
+		b.dirty = true
+	}
+	return retained
+}
+
+func reverse(s []byte) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
 }
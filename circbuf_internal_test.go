@@ -0,0 +1,58 @@
+package circbuf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuffer_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		corrupt func(b *Buffer)
+		errs    []string
+	}{
+		{name: "valid", corrupt: func(b *Buffer) {}},
+		{name: "write cursor past the ring", corrupt: func(b *Buffer) { b.writeCursor = 8 }, errs: []string{"write cursor 8"}},
+		{name: "negative read cursor", corrupt: func(b *Buffer) { b.readCursor = -2 }, errs: []string{"read cursor -2"}},
+		{name: "negative written", corrupt: func(b *Buffer) { b.written = -1 }, errs: []string{"negative written count -1"}},
+		{name: "written not matching the cursor", corrupt: func(b *Buffer) { b.written = 2 }, errs: []string{"write cursor 0 doesn't match the 2 bytes written"}},
+		{name: "ring past the slice", corrupt: func(b *Buffer) { b.data = b.data[:9] }, errs: []string{"don't fit in a 9 bytes slice"}},
+		{name: "too many unread bytes", corrupt: func(b *Buffer) { b.unread = 9 }, errs: []string{"9 unread bytes out of 8 retained"}},
+		{
+			name: "all at once",
+			corrupt: func(b *Buffer) {
+				b.size = 0
+				b.writeCursor = -1
+				b.readCursor = 20
+			},
+			errs: []string{"invalid size 0", "write cursor -1", "read cursor 20"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewBuffer(make([]byte, 2+8), 2, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			b.Write([]byte("hello world"))
+			tt.corrupt(b)
+
+			err = b.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, msg := range tt.errs {
+				if !strings.Contains(err.Error(), msg) {
+					t.Fatalf("expected the error to mention %q but got %q", msg, err)
+				}
+			}
+		})
+	}
+}
@@ -2,10 +2,16 @@ package circbuf_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 
 	mmap "github.com/edsrzf/mmap-go"
 	"github.com/mattetti/circbuf"
@@ -52,6 +58,7 @@ func ExampleWrite() {
 func TestBuffer_Impl(t *testing.T) {
 	var _ io.Writer = &circbuf.Buffer{}
 	var _ io.Reader = &circbuf.Buffer{}
+	var _ io.ReadWriteSeeker = &circbuf.Buffer{}
 }
 
 // it's the caller responsibility to close the file
@@ -175,6 +182,39 @@ func TestBuffer_ShortRead(t *testing.T) {
 	}
 }
 
+func TestBuffer_ReadOverflow(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 4), 0, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("abcd"))
+	buf.Write([]byte("ef"))
+
+	// the default Read goes over the ring from its own position
+	out := make([]byte, 4)
+	if n, _ := buf.Read(out); n != 4 || string(out) != "efcd" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+	// the unread data starts at the oldest retained byte
+	p, err := buf.ReadN(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(p) != "cdef" {
+		t.Fatalf("bad: %q", p)
+	}
+
+	buf.SetEOFOnDrain(true)
+	buf.Write([]byte("ghijkl"))
+	n, err := buf.Read(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "ijkl" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+}
+
 func TestCircBuffer_FullWrite(t *testing.T) {
 	inp := []byte("hello world")
 
@@ -389,3 +429,3046 @@ func TestCircBuffer_Reset(t *testing.T) {
 		t.Fatalf("bad: %v", string(buf.Bytes()))
 	}
 }
+
+func TestCircBuffer_ResetReadPosition(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("abcdefgh"))
+	out := make([]byte, 10)
+	if n, _ := buf.Read(out); string(out[:n]) != "abcdefghab" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+
+	// the default Read carries on from where it stopped
+	buf.Reset()
+	buf.Write([]byte("ABCDEFGH"))
+	out = out[:4]
+	if n, _ := buf.Read(out); string(out[:n]) != "CDEF" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+}
+
+func TestCircBuffer_ResetKeep(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+		keep   int64
+		expect string
+	}{
+		{name: "not wrapped", inputs: []string{"hello"}, keep: 3, expect: "llo"},
+		{name: "not wrapped, keep more than retained", inputs: []string{"hello"}, keep: 20, expect: "hello"},
+		{name: "full, not wrapped", inputs: []string{"hello wo"}, keep: 4, expect: "o wo"},
+		{name: "wrapped", inputs: []string{"hello world"}, keep: 5, expect: "world"},
+		{name: "wrapped, keep everything", inputs: []string{"hello ", "world"}, keep: 8, expect: "lo world"},
+		{name: "keep nothing", inputs: []string{"hello world"}, keep: 0, expect: ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 4+8), 4, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				if _, err := buf.Write([]byte(in)); err != nil {
+					t.Fatalf("err: %v", err)
+				}
+			}
+
+			buf.ResetKeep(tt.keep)
+
+			if !bytes.Equal(buf.Bytes(), []byte(tt.expect)) {
+				t.Fatalf("expected %q but got %q", tt.expect, buf.Bytes())
+			}
+			if buf.TotalWritten() != int64(len(tt.expect)) {
+				t.Fatalf("expected %d bytes written but got %d", len(tt.expect), buf.TotalWritten())
+			}
+
+			// new writes must follow the kept bytes
+			if _, err := buf.Write([]byte("!")); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			expect := tt.expect + "!"
+			if len(expect) > 8 {
+				expect = expect[len(expect)-8:]
+			}
+			if !bytes.Equal(buf.Bytes(), []byte(expect)) {
+				t.Fatalf("expected %q after writing but got %q", expect, buf.Bytes())
+			}
+		})
+	}
+}
+
+func TestCopyBuffer(t *testing.T) {
+	src, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	src.Write([]byte("hello world"))
+
+	testCases := []struct {
+		name   string
+		skip   int64
+		size   int64
+		expect string
+	}{
+		{name: "same size", skip: 2, size: 8, expect: "lo world"},
+		{name: "larger", skip: 0, size: 16, expect: "lo world"},
+		{name: "smaller", skip: 4, size: 5, expect: "world"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := make([]byte, tt.skip+tt.size)
+			buf, err := circbuf.CopyBuffer(src, dst, tt.skip, tt.size)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), []byte(tt.expect)) {
+				t.Fatalf("expected %q but got %q", tt.expect, buf.Bytes())
+			}
+			if buf.Size() != tt.size {
+				t.Fatalf("expected a size of %d but got %d", tt.size, buf.Size())
+			}
+
+			// the copy doesn't share its backing data with the source
+			buf.Write([]byte("!"))
+			if !bytes.Equal(src.Bytes(), []byte("lo world")) {
+				t.Fatalf("the source buffer was modified: %q", src.Bytes())
+			}
+		})
+	}
+}
+
+func TestBuffer_IOCopy(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+	}{
+		{name: "empty"},
+		{name: "not wrapped", inputs: []string{"hello"}},
+		{name: "full", inputs: []string{"hello wo"}},
+		{name: "wrapped", inputs: []string{"hello ", "world"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 3+8), 3, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+			buf.SetEOFOnDrain(true)
+
+			var out bytes.Buffer
+			n, err := io.Copy(&out, buf)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if n != buf.Len() {
+				t.Fatalf("expected to copy %d bytes but copied %d", buf.Len(), n)
+			}
+			if !bytes.Equal(out.Bytes(), buf.Bytes()) {
+				t.Fatalf("expected %q but got %q", buf.Bytes(), out.Bytes())
+			}
+
+			// everything was read
+			n, err = io.Copy(&out, buf)
+			if err != nil || n != 0 {
+				t.Fatalf("expected nothing left to copy but got %d, %v", n, err)
+			}
+
+			// new data can be read
+			buf.Write([]byte("!"))
+			out.Reset()
+			if _, err := io.Copy(&out, buf); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if out.String() != "!" {
+				t.Fatalf("expected to read the new data but got %q", out.Bytes())
+			}
+		})
+	}
+}
+
+func TestBuffer_PhysicalIndex(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+		expect string
+	}{
+		{name: "empty"},
+		{name: "not wrapped", inputs: []string{"hello"}, expect: "hello"},
+		{name: "wrapped", inputs: []string{"hello ", "world"}, expect: "lo world"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, 5+8)
+			buf, err := circbuf.NewBuffer(data, 5, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			for i := range tt.expect {
+				idx := buf.PhysicalIndex(int64(i))
+				if idx < 5 || idx >= int64(len(data)) {
+					t.Fatalf("index %d for logical position %d is out of the ring", idx, i)
+				}
+				if data[idx] != tt.expect[i] {
+					t.Fatalf("expected %q at logical position %d but got %q", tt.expect[i], i, data[idx])
+				}
+			}
+			for _, logical := range []int64{-1, int64(len(tt.expect)), 100} {
+				if idx := buf.PhysicalIndex(logical); idx != -1 {
+					t.Fatalf("expected -1 for logical position %d but got %d", logical, idx)
+				}
+			}
+		})
+	}
+}
+
+func TestBuffer_Fill(t *testing.T) {
+	testCases := []struct {
+		name   string
+		prefix string
+		n      int
+		expect string
+	}{
+		{name: "empty buffer", n: 3, expect: "---"},
+		{name: "after data", prefix: "hello", n: 2, expect: "hello--"},
+		{name: "wrapping", prefix: "hello", n: 5, expect: "lo-----"},
+		{name: "more than size", prefix: "hello", n: 20, expect: "-------"},
+		{name: "nothing", prefix: "hello", n: 0, expect: "hello"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, 2+7)
+			buf, err := circbuf.NewBuffer(data, 2, 7)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			buf.Write([]byte(tt.prefix))
+
+			n, err := buf.Fill('-', tt.n)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if n != tt.n {
+				t.Fatalf("expected %d bytes written but got %d", tt.n, n)
+			}
+			if !bytes.Equal(buf.Bytes(), []byte(tt.expect)) {
+				t.Fatalf("expected %q but got %q", tt.expect, buf.Bytes())
+			}
+			if buf.TotalWritten() != int64(len(tt.prefix)+tt.n) {
+				t.Fatalf("bad total: %d", buf.TotalWritten())
+			}
+			if data[0] != 0 || data[1] != 0 {
+				t.Fatalf("the offset was overwritten: %v", data[:2])
+			}
+		})
+	}
+}
+
+func TestBuffer_IndexByte(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+		c      byte
+		expect int64
+	}{
+		{name: "empty", c: 'a', expect: -1},
+		{name: "not wrapped", inputs: []string{"hello"}, c: 'l', expect: 2},
+		{name: "missing", inputs: []string{"hello ", "world"}, c: 'h', expect: -1},
+		{name: "before the wrap", inputs: []string{"hello ", "world"}, c: 'o', expect: 1},
+		{name: "after the wrap", inputs: []string{"hello ", "world"}, c: 'd', expect: 7},
+		{name: "first one wins", inputs: []string{"hello ", "world"}, c: 'l', expect: 0},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+			if i := buf.IndexByte(tt.c); i != tt.expect {
+				t.Fatalf("expected %d but got %d", tt.expect, i)
+			}
+			if i := int64(bytes.IndexByte(buf.Bytes(), tt.c)); i != tt.expect {
+				t.Fatalf("expected bytes.IndexByte to return %d but got %d", tt.expect, i)
+			}
+		})
+	}
+}
+
+func TestBuffer_WriteUntilFull(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	steps := []struct {
+		input string
+		full  bool
+	}{
+		{input: "hel", full: false},
+		{input: "lo ", full: false},
+		{input: "", full: false},
+		{input: "wo", full: true},
+		{input: "rld", full: false},
+	}
+	for _, step := range steps {
+		n, full, err := buf.WriteUntilFull([]byte(step.input))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != len(step.input) {
+			t.Fatalf("bad: %v", n)
+		}
+		if full != step.full {
+			t.Fatalf("expected full to be %t after writing %q (%d bytes written)", step.full, step.input, buf.TotalWritten())
+		}
+	}
+
+	t.Run("overflowing write", func(t *testing.T) {
+		buf.Reset()
+		if _, full, _ := buf.WriteUntilFull([]byte("hello world")); !full {
+			t.Fatal("expected the write to fill the buffer")
+		}
+	})
+}
+
+func TestCircBuffer_Truncate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+		n      int64
+		expect string
+	}{
+		{name: "not wrapped", inputs: []string{"hello"}, n: 3, expect: "hel"},
+		{name: "not wrapped, more than retained", inputs: []string{"hello"}, n: 20, expect: "hello"},
+		{name: "full, not wrapped", inputs: []string{"hello wo"}, n: 4, expect: "hell"},
+		{name: "wrapped", inputs: []string{"hello ", "world"}, n: 5, expect: "lo wo"},
+		{name: "wrapped, keep everything", inputs: []string{"hello ", "world"}, n: 8, expect: "lo world"},
+		{name: "keep nothing", inputs: []string{"hello world"}, n: 0, expect: ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 4+8), 4, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			buf.Truncate(tt.n)
+
+			if !bytes.Equal(buf.Bytes(), []byte(tt.expect)) {
+				t.Fatalf("expected %q but got %q", tt.expect, buf.Bytes())
+			}
+			if buf.TotalWritten() != int64(len(tt.expect)) {
+				t.Fatalf("expected %d bytes written but got %d", len(tt.expect), buf.TotalWritten())
+			}
+
+			// new writes must follow the kept bytes
+			buf.Write([]byte("!"))
+			expect := tt.expect + "!"
+			if len(expect) > 8 {
+				expect = expect[len(expect)-8:]
+			}
+			if !bytes.Equal(buf.Bytes(), []byte(expect)) {
+				t.Fatalf("expected %q after writing but got %q", expect, buf.Bytes())
+			}
+		})
+	}
+
+	t.Run("read position", func(t *testing.T) {
+		buf, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+		buf.Write([]byte("hello world"))
+		buf.SetEOFOnDrain(true)
+		buf.Read(make([]byte, 2))
+
+		buf.Truncate(5)
+		out, _ := io.ReadAll(buf)
+		if string(out) != " wo" {
+			t.Fatalf("expected to read %q but got %q", " wo", out)
+		}
+	})
+}
+
+func TestNewBuffer_Validation(t *testing.T) {
+	testCases := []struct {
+		name string
+		m    []byte
+		skip int64
+		size int64
+	}{
+		{name: "negative offset", m: make([]byte, 10), skip: -1, size: 5},
+		{name: "zero size", m: make([]byte, 10), skip: 0, size: 0},
+		{name: "offset out of the slice", m: make([]byte, 10), skip: 11, size: 5},
+		{name: "nil slice", skip: 1, size: 1},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := circbuf.NewBuffer(tt.m, tt.skip, tt.size); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestBuffer_ResetWith(t *testing.T) {
+	first := make([]byte, 2+8)
+	second := []byte("####....")
+
+	buf, err := circbuf.NewBuffer(first, 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+
+	if err := buf.ResetWith(second, 4, 4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if buf.Len() != 0 || buf.TotalWritten() != 0 {
+		t.Fatalf("expected an empty buffer, got %q", buf.Bytes())
+	}
+	if buf.Size() != 4 {
+		t.Fatalf("bad size: %d", buf.Size())
+	}
+
+	buf.Write([]byte("recycled"))
+	if string(buf.Bytes()) != "cled" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+	if string(second) != "####cled" {
+		t.Fatalf("expected the new backing slice to be used but got %q", second)
+	}
+	if string(first[2:]) != "lo world" {
+		t.Fatalf("the previous backing slice was modified: %q", first[2:])
+	}
+
+	t.Run("invalid layout", func(t *testing.T) {
+		if err := buf.ResetWith(first, 4, -1); err == nil {
+			t.Fatal("expected an error")
+		}
+		// the buffer is left untouched
+		if string(buf.Bytes()) != "cled" {
+			t.Fatalf("bad: %q", buf.Bytes())
+		}
+	})
+}
+
+func TestBuffer_CopyTo(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+
+	testCases := []struct {
+		name   string
+		dst    []byte
+		expect string
+	}{
+		{name: "smaller", dst: make([]byte, 5), expect: "lo wo"},
+		{name: "equal", dst: make([]byte, 8), expect: "lo world"},
+		{name: "larger", dst: make([]byte, 12), expect: "lo world"},
+		{name: "empty", dst: nil, expect: ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			n := buf.CopyTo(tt.dst)
+			if n != len(tt.expect) {
+				t.Fatalf("expected %d bytes copied but got %d", len(tt.expect), n)
+			}
+			if string(tt.dst[:n]) != tt.expect {
+				t.Fatalf("expected %q but got %q", tt.expect, tt.dst[:n])
+			}
+		})
+	}
+
+	dst := make([]byte, 8)
+	if allocs := testing.AllocsPerRun(10, func() { buf.CopyTo(dst) }); allocs != 0 {
+		t.Fatalf("expected no allocation but got %v", allocs)
+	}
+}
+
+func TestBuffer_Dirty(t *testing.T) {
+	f, m := createTestMmap(t, t.Name(), 2+8)
+	defer func() {
+		m.Unmap()
+		f.Close()
+		os.Remove(t.Name() + "_testfile")
+	}()
+	buf, err := circbuf.NewBuffer(m, 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if buf.Dirty() {
+		t.Fatal("a new buffer shouldn't be dirty")
+	}
+
+	buf.Write([]byte("hello"))
+	if !buf.Dirty() {
+		t.Fatal("expected the buffer to be dirty after a write")
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.MarkSynced()
+	if buf.Dirty() {
+		t.Fatal("expected the buffer to be clean after MarkSynced")
+	}
+
+	buf.Write(nil)
+	if buf.Dirty() {
+		t.Fatal("an empty write shouldn't make the buffer dirty")
+	}
+
+	buf.Fill(' ', 1)
+	if !buf.Dirty() {
+		t.Fatal("expected the buffer to be dirty after a fill")
+	}
+	buf.MarkSynced()
+
+	buf.Write([]byte("world"))
+	buf.MarkSynced()
+	buf.ResetKeep(3)
+	if !buf.Dirty() {
+		t.Fatal("expected the buffer to be dirty after moving data around")
+	}
+}
+
+func TestBuffer_ReadN(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+
+	steps := []struct {
+		name   string
+		n      int
+		expect string
+		err    error
+	}{
+		{name: "exact", n: 3, expect: "lo "},
+		{name: "across the wrap", n: 3, expect: "wor"},
+		{name: "short", n: 4, expect: "ld", err: io.ErrUnexpectedEOF},
+		{name: "drained", n: 1, expect: "", err: io.ErrUnexpectedEOF},
+		{name: "nothing", n: 0, expect: ""},
+	}
+	for _, step := range steps {
+		out, err := buf.ReadN(step.n)
+		if err != step.err {
+			t.Fatalf("%s: expected error %v but got %v", step.name, step.err, err)
+		}
+		if string(out) != step.expect {
+			t.Fatalf("%s: expected %q but got %q", step.name, step.expect, out)
+		}
+	}
+
+	t.Run("oversized", func(t *testing.T) {
+		buf.Write([]byte("hello world"))
+		out, err := buf.ReadN(100)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected ErrUnexpectedEOF but got %v", err)
+		}
+		if string(out) != "lo world" {
+			t.Fatalf("bad: %q", out)
+		}
+	})
+
+	if _, err := buf.ReadN(-1); err == nil {
+		t.Fatal("expected an error for a negative count")
+	}
+}
+
+func TestBuffer_WriteRune(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 6), 0, 6)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the euro sign straddles the wrap boundary
+	for _, r := range "abcd€" {
+		n, err := buf.WriteRune(r)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != utf8.RuneLen(r) {
+			t.Fatalf("expected %d bytes written for %q but got %d", utf8.RuneLen(r), r, n)
+		}
+	}
+
+	if !utf8.Valid(buf.Bytes()) {
+		t.Fatalf("invalid UTF-8: %v", buf.Bytes())
+	}
+	if string(buf.Bytes()) != "bcd€" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+
+	if allocs := testing.AllocsPerRun(10, func() { buf.WriteRune('é') }); allocs != 0 {
+		t.Fatalf("expected no allocation but got %v", allocs)
+	}
+}
+
+func TestBuffer_Overwritten(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 4+8), 4, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	steps := []struct {
+		input  string
+		expect int64
+	}{
+		{input: "hello", expect: 0},
+		{input: " wo", expect: 0},
+		{input: "r", expect: 1},
+		{input: "ld", expect: 3},
+		{input: "hello world", expect: 14},
+	}
+	for _, step := range steps {
+		buf.Write([]byte(step.input))
+		if n := buf.Overwritten(); n != step.expect {
+			t.Fatalf("expected %d bytes overwritten after writing %q but got %d", step.expect, step.input, n)
+		}
+	}
+}
+
+func TestNewBufferBounded(t *testing.T) {
+	m := []byte("HEAD........TAIL")
+	buf, err := circbuf.NewBufferBounded(m, 4, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf.Write([]byte("hello world"))
+	buf.Fill('!', 3)
+	buf.Write([]byte("bounded writes"))
+
+	if string(buf.Bytes()) != "d writes" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+	if string(m[:4]) != "HEAD" || string(m[12:]) != "TAIL" {
+		t.Fatalf("bytes outside of the ring were modified: %q", m)
+	}
+
+	if _, err := circbuf.NewBufferBounded(m, 10, 8); err == nil {
+		t.Fatal("expected an error for a ring going past the end of the slice")
+	}
+}
+
+func TestBuffer_Writer(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 6), 0, 6)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var w io.Writer = buf.Writer()
+	if _, ok := w.(io.Reader); ok {
+		t.Fatal("the writer shouldn't be an io.Reader")
+	}
+	if _, ok := w.(*circbuf.Buffer); ok {
+		t.Fatal("the writer shouldn't expose the buffer")
+	}
+
+	fmt.Fprintf(w, "hello %s", "world")
+	if string(buf.Bytes()) != " world" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+}
+
+func TestNewBufferFromExisting(t *testing.T) {
+	f, m := createTestMmap(t, t.Name(), 4+8)
+	defer func() {
+		m.Unmap()
+		f.Close()
+		os.Remove(t.Name() + "_testfile")
+	}()
+
+	buf, err := circbuf.NewBuffer(m, 4, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello "))
+	buf.Write([]byte("world"))
+	expect := string(buf.Bytes())
+
+	// "reopen" the mapped file with the cursor state
+	restored, err := circbuf.NewBufferFromExisting(m, 4, 8, 3, 11)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(restored.Bytes()) != expect {
+		t.Fatalf("expected %q but got %q", expect, restored.Bytes())
+	}
+	if restored.TotalWritten() != 11 {
+		t.Fatalf("bad total: %d", restored.TotalWritten())
+	}
+
+	restored.SetEOFOnDrain(true)
+	out, _ := io.ReadAll(restored)
+	if string(out) != expect {
+		t.Fatalf("expected to read %q but got %q", expect, out)
+	}
+
+	restored.Write([]byte("!"))
+	if string(restored.Bytes()) != "o world!" {
+		t.Fatalf("bad: %q", restored.Bytes())
+	}
+
+	t.Run("invalid state", func(t *testing.T) {
+		testCases := []struct {
+			name                 string
+			size, cursor, writes int64
+		}{
+			{name: "cursor past the ring", size: 8, cursor: 8, writes: 20},
+			{name: "negative cursor", size: 8, cursor: -1, writes: 20},
+			{name: "negative written", size: 8, cursor: 0, writes: -1},
+			{name: "cursor not matching written", size: 8, cursor: 3, writes: 5},
+			{name: "ring past the slice", size: 10, cursor: 3, writes: 20},
+		}
+		for _, tt := range testCases {
+			if _, err := circbuf.NewBufferFromExisting(m, 4, tt.size, tt.cursor, tt.writes); err == nil {
+				t.Fatalf("%s: expected an error", tt.name)
+			}
+		}
+	})
+}
+
+func TestBuffer_Offset(t *testing.T) {
+	for _, skip := range []int64{0, 1, 12} {
+		buf, err := circbuf.NewBuffer(make([]byte, skip+8), skip, 8)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf.Write([]byte("hello world"))
+		buf.ResetKeep(4)
+		buf.Truncate(2)
+		if buf.Offset() != skip {
+			t.Fatalf("expected an offset of %d but got %d", skip, buf.Offset())
+		}
+	}
+
+	buf, _ := circbuf.NewBufferBounded(make([]byte, 20), 12, 8)
+	if buf.Offset() != 0 {
+		t.Fatalf("expected a bounded buffer to have no offset but got %d", buf.Offset())
+	}
+}
+
+func TestBuffer_AppendTo(t *testing.T) {
+	first, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	first.Write([]byte("hello world"))
+	second, _ := circbuf.NewBuffer(make([]byte, 2+6), 2, 6)
+	second.Write([]byte(", bye"))
+
+	var dst bytes.Buffer
+	dst.WriteString(">")
+	first.AppendTo(&dst)
+	second.AppendTo(&dst)
+
+	if dst.String() != ">lo world, bye" {
+		t.Fatalf("bad: %q", dst.String())
+	}
+
+	// the capacity is reused
+	dst.Reset()
+	if allocs := testing.AllocsPerRun(10, func() {
+		dst.Reset()
+		first.AppendTo(&dst)
+		second.AppendTo(&dst)
+	}); allocs != 0 {
+		t.Fatalf("expected no allocation but got %v", allocs)
+	}
+}
+
+// alphabet is an infinite reader going over the alphabet.
+type alphabet struct {
+	served int64
+}
+
+func (a *alphabet) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a' + byte(a.served%26)
+		a.served++
+	}
+	return len(p), nil
+}
+
+func TestBuffer_WriteReaderN(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	src := &alphabet{}
+	n, err := buf.WriteReaderN(src, 100)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 100 || src.served != 100 {
+		t.Fatalf("expected 100 bytes to be read but read %d, %d served", n, src.served)
+	}
+	if buf.TotalWritten() != 100 {
+		t.Fatalf("bad total: %d", buf.TotalWritten())
+	}
+	// bytes 92 to 99 of the alphabet
+	if string(buf.Bytes()) != "opqrstuv" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+
+	t.Run("short reader", func(t *testing.T) {
+		buf.Reset()
+		n, err := buf.WriteReaderN(strings.NewReader("hello world"), 100)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != 11 {
+			t.Fatalf("bad: %d", n)
+		}
+		if string(buf.Bytes()) != "lo world" {
+			t.Fatalf("bad: %q", buf.Bytes())
+		}
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		buf.Reset()
+		r := io.MultiReader(strings.NewReader("hello"), iotest.ErrReader(io.ErrClosedPipe))
+		n, err := buf.WriteReaderN(r, 100)
+		if err != io.ErrClosedPipe {
+			t.Fatalf("expected the read error but got %v", err)
+		}
+		if n != 5 || string(buf.Bytes()) != "hello" {
+			t.Fatalf("bad: %d, %q", n, buf.Bytes())
+		}
+	})
+}
+
+func TestBuffer_SetFlusher(t *testing.T) {
+	f, m := createTestMmap(t, t.Name(), 2+8)
+	defer func() {
+		m.Unmap()
+		f.Close()
+		os.Remove(t.Name() + "_testfile")
+	}()
+	buf, err := circbuf.NewBuffer(m, 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var flushes int
+	buf.SetFlusher(func() error {
+		flushes++
+		return m.Flush()
+	}, 10)
+
+	steps := []struct {
+		input   string
+		flushes int
+	}{
+		{input: "hello", flushes: 0},
+		{input: " wor", flushes: 0},
+		{input: "l", flushes: 1},
+		{input: "d", flushes: 1},
+		{input: "a very long line", flushes: 2},
+		{input: "123456789", flushes: 2},
+		{input: "0", flushes: 3},
+	}
+	for _, step := range steps {
+		if _, err := buf.Write([]byte(step.input)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if flushes != step.flushes {
+			t.Fatalf("expected %d flushes after writing %q but got %d", step.flushes, step.input, flushes)
+		}
+	}
+
+	t.Run("flush error", func(t *testing.T) {
+		buf.SetFlusher(func() error { return io.ErrShortWrite }, 2)
+		if _, err := buf.Write([]byte("a")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		n, err := buf.Write([]byte("b"))
+		if err != io.ErrShortWrite {
+			t.Fatalf("expected the flush error but got %v", err)
+		}
+		if n != 1 {
+			t.Fatalf("bad: %d", n)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		buf.SetFlusher(nil, 0)
+		if _, err := buf.Write([]byte("hello world")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	})
+}
+
+func TestBuffer_WriteToN(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 3+8), 3, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+
+	var out bytes.Buffer
+	for _, expect := range []string{"lo ", "wor", "ld", ""} {
+		out.Reset()
+		n, err := buf.WriteToN(&out, 3)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != int64(len(expect)) || out.String() != expect {
+			t.Fatalf("expected %q but wrote %d bytes: %q", expect, n, out.String())
+		}
+	}
+
+	t.Run("whole wrapped buffer", func(t *testing.T) {
+		buf.Write([]byte("hello world"))
+		out.Reset()
+		n, err := buf.WriteToN(&out, 100)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != 8 || out.String() != "lo world" {
+			t.Fatalf("bad: %d, %q", n, out.String())
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		buf.Write([]byte("hello world"))
+		w := &limitedWriter{max: 2}
+		n, err := buf.WriteToN(w, 100)
+		if err != io.ErrShortWrite {
+			t.Fatalf("expected ErrShortWrite but got %v", err)
+		}
+		if n != 2 {
+			t.Fatalf("bad: %d", n)
+		}
+		// only what was written was consumed
+		out.Reset()
+		buf.WriteToN(&out, 100)
+		if out.String() != " world" {
+			t.Fatalf("bad: %q", out.String())
+		}
+	})
+}
+
+// limitedWriter accepts at most max bytes per write.
+type limitedWriter struct {
+	max int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		return w.max, nil
+	}
+	return len(p), nil
+}
+
+func TestBuffer_Seq(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf.Write([]byte("hello"))
+	if seq := buf.OldestSeq(); seq != 0 {
+		t.Fatalf("expected 0 before overflowing but got %d", seq)
+	}
+	if seq := buf.SeqAt(4); seq != 4 {
+		t.Fatalf("bad: %d", seq)
+	}
+
+	stream := "hello world, sequence numbers"
+	buf.Write([]byte(stream[5:]))
+	if seq := buf.OldestSeq(); seq != int64(len(stream)-8) {
+		t.Fatalf("expected %d but got %d", len(stream)-8, seq)
+	}
+	retained := buf.Bytes()
+	for i := range retained {
+		seq := buf.SeqAt(int64(i))
+		if stream[seq] != retained[i] {
+			t.Fatalf("byte %d has sequence number %d which is %q in the stream, not %q", i, seq, stream[seq], retained[i])
+		}
+	}
+	for _, logical := range []int64{-1, 8} {
+		if seq := buf.SeqAt(logical); seq != -1 {
+			t.Fatalf("expected -1 for logical position %d but got %d", logical, seq)
+		}
+	}
+}
+
+func TestBuffer_AppendBytes(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+	}{
+		{name: "empty"},
+		{name: "not wrapped", inputs: []string{"hello"}},
+		{name: "wrapped", inputs: []string{"hello ", "world"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			dst := []byte("> ")
+			got := buf.AppendBytes(dst)
+			expect := append([]byte("> "), buf.Bytes()...)
+			if !bytes.Equal(got, expect) {
+				t.Fatalf("expected %q but got %q", expect, got)
+			}
+
+			scratch := make([]byte, 0, 16)
+			got = buf.AppendBytes(scratch)
+			if len(got) > 0 && &got[0] != &scratch[:1][0] {
+				t.Fatal("expected the capacity of dst to be reused")
+			}
+			if allocs := testing.AllocsPerRun(10, func() { buf.AppendBytes(scratch[:0]) }); allocs != 0 {
+				t.Fatalf("expected no allocation but got %v", allocs)
+			}
+		})
+	}
+}
+
+func TestBuffer_SetMaxWrite(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	huge := bytes.Repeat([]byte("x"), 1024)
+	if _, err := buf.Write(huge); err != nil {
+		t.Fatalf("expected no limit by default but got %v", err)
+	}
+
+	buf.Reset()
+	buf.SetMaxWrite(16)
+	n, err := buf.Write(huge)
+	if err != circbuf.ErrMaxWrite {
+		t.Fatalf("expected ErrMaxWrite but got %v", err)
+	}
+	if n != 0 || buf.TotalWritten() != 0 {
+		t.Fatalf("the rejected write was written")
+	}
+	if _, err := buf.Write(huge[:16]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf.SetMaxWrite(0)
+	if _, err := buf.Write(huge); err != nil {
+		t.Fatalf("expected the limit to be removed but got %v", err)
+	}
+}
+
+func TestBuffer_SetMaxWriteAllMethods(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.SetMaxWrite(2)
+
+	if _, err := buf.Fill('z', 1<<20); err != circbuf.ErrMaxWrite {
+		t.Fatalf("expected ErrMaxWrite but got %v", err)
+	}
+	if _, err := buf.WriteReaderN(strings.NewReader("abcdefgh"), 8); err != circbuf.ErrMaxWrite {
+		t.Fatalf("expected ErrMaxWrite but got %v", err)
+	}
+	if _, err := buf.WriteV([]byte("ab"), []byte("c")); err != circbuf.ErrMaxWrite {
+		t.Fatalf("expected ErrMaxWrite but got %v", err)
+	}
+	copy(buf.NextWriteSlice(), "abc")
+	if err := buf.Commit(3); err != circbuf.ErrMaxWrite {
+		t.Fatalf("expected ErrMaxWrite but got %v", err)
+	}
+	if n := buf.TotalWritten(); n != 0 {
+		t.Fatalf("bad: %d bytes written", n)
+	}
+
+	if err := buf.Commit(2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := buf.Fill('z', 2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(buf.Bytes()); s != "abzz" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestBuffer_Seek(t *testing.T) {
+	var rws io.ReadWriteSeeker
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.SetEOFOnDrain(true)
+	rws = buf
+
+	io.WriteString(rws, "hello ")
+	io.WriteString(rws, "world")
+
+	pos, err := rws.Seek(0, io.SeekStart)
+	if err != nil || pos != 0 {
+		t.Fatalf("bad: %d, %v", pos, err)
+	}
+	out, err := io.ReadAll(rws)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out) != "lo world" {
+		t.Fatalf("bad: %q", out)
+	}
+
+	testCases := []struct {
+		name   string
+		offset int64
+		whence int
+		pos    int64
+		expect string
+	}{
+		{name: "end", offset: -5, whence: io.SeekEnd, pos: 3, expect: "world"},
+		{name: "current", offset: -2, whence: io.SeekCurrent, pos: 6, expect: "ld"},
+		{name: "start", offset: 2, whence: io.SeekStart, pos: 2, expect: " world"},
+		{name: "at the end", offset: 0, whence: io.SeekEnd, pos: 8, expect: ""},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := rws.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if pos != tt.pos {
+				t.Fatalf("expected position %d but got %d", tt.pos, pos)
+			}
+			out, _ := io.ReadAll(rws)
+			if string(out) != tt.expect {
+				t.Fatalf("expected %q but got %q", tt.expect, out)
+			}
+		})
+	}
+
+	for _, offset := range []int64{-1, 9} {
+		if _, err := rws.Seek(offset, io.SeekStart); err == nil {
+			t.Fatalf("expected an error seeking to %d", offset)
+		}
+	}
+	if _, err := rws.Seek(0, 42); err == nil {
+		t.Fatal("expected an error for an invalid whence")
+	}
+}
+
+func TestBuffer_WriteBuffer(t *testing.T) {
+	testCases := []struct {
+		name   string
+		other  []string
+		expect string
+	}{
+		{name: "empty", expect: "abc"},
+		{name: "fits", other: []string{"hey"}, expect: "abchey"},
+		{name: "not wrapped, overflowing", other: []string{"hello"}, expect: "bchello"},
+		{name: "wrapped", other: []string{"hello ", "world"}, expect: "o world"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			other, _ := circbuf.NewBuffer(make([]byte, 7), 0, 7)
+			for _, in := range tt.other {
+				other.Write([]byte(in))
+			}
+			buf, err := circbuf.NewBuffer(make([]byte, 2+7), 2, 7)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			buf.Write([]byte("abc"))
+
+			n, err := buf.WriteBuffer(other)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if int64(n) != other.Len() {
+				t.Fatalf("expected %d bytes written but got %d", other.Len(), n)
+			}
+			if string(buf.Bytes()) != tt.expect {
+				t.Fatalf("expected %q but got %q", tt.expect, buf.Bytes())
+			}
+		})
+	}
+}
+
+func TestBuffer_WriteBufferRejected(t *testing.T) {
+	// the retained bytes of other are split around the end of its ring
+	other, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	other.Write([]byte("abcd"))
+	other.Write([]byte("efghijkl"))
+
+	t.Run("max write", func(t *testing.T) {
+		buf, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+		buf.SetMaxWrite(5)
+		if _, err := buf.WriteBuffer(other); err != circbuf.ErrMaxWrite {
+			t.Fatalf("expected ErrMaxWrite but got %v", err)
+		}
+		if n := buf.TotalWritten(); n != 0 {
+			t.Fatalf("bad: %d bytes written", n)
+		}
+	})
+
+	t.Run("protected", func(t *testing.T) {
+		buf, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+		buf.Write([]byte("x"))
+		buf.Protect(1)
+		if _, err := buf.WriteBuffer(other); err != circbuf.ErrProtected {
+			t.Fatalf("expected ErrProtected but got %v", err)
+		}
+		if s := string(buf.Bytes()); s != "x" {
+			t.Fatalf("bad: %q", s)
+		}
+	})
+}
+
+func TestBuffer_BytesReversed(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out := buf.BytesReversed(); len(out) != 0 {
+		t.Fatalf("bad: %q", out)
+	}
+
+	buf.Write([]byte("hello "))
+	if out := buf.BytesReversed(); string(out) != " olleh" {
+		t.Fatalf("bad: %q", out)
+	}
+	buf.Write([]byte("world"))
+	if out := buf.BytesReversed(); string(out) != "dlrow ol" {
+		t.Fatalf("bad: %q", out)
+	}
+	// the buffer isn't modified
+	if string(buf.Bytes()) != "lo world" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+}
+
+func TestBuffer_LinesReversed(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		expect []string
+	}{
+		{name: "empty"},
+		{name: "no line feed", input: "hello", expect: []string{"hello"}},
+		{name: "trailing line feed", input: "one\ntwo\n", expect: []string{"two", "one"}},
+		{name: "partial last line", input: "one\ntwo\nthr", expect: []string{"thr", "two", "one"}},
+		{name: "wrapped", input: "zero\none\ntwo\nthree\n", expect: []string{"three", "two", "one", "o"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 16), 0, 16)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			buf.Write([]byte(tt.input))
+
+			lines := buf.LinesReversed()
+			if len(lines) != len(tt.expect) {
+				t.Fatalf("expected %q but got %q", tt.expect, lines)
+			}
+			for i := range lines {
+				if string(lines[i]) != tt.expect[i] {
+					t.Fatalf("expected %q but got %q", tt.expect, lines)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBufferFull(t *testing.T) {
+	testCases := []struct {
+		name string
+		m    []byte
+		skip int64
+		size int64
+		err  bool
+	}{
+		{name: "no offset", m: make([]byte, 8), skip: 0, size: 8},
+		{name: "offset", m: make([]byte, 8), skip: 3, size: 5},
+		{name: "last byte", m: make([]byte, 8), skip: 7, size: 1},
+		{name: "nothing left", m: make([]byte, 8), skip: 8, err: true},
+		{name: "offset past the slice", m: make([]byte, 8), skip: 9, err: true},
+		{name: "negative offset", m: make([]byte, 8), skip: -1, err: true},
+		{name: "empty slice", skip: 0, err: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBufferFull(tt.m, tt.skip)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if buf.Size() != tt.size {
+				t.Fatalf("expected a size of %d but got %d", tt.size, buf.Size())
+			}
+			buf.Write([]byte("hello world"))
+			expect := "hello world"[11-tt.size:]
+			if string(buf.Bytes()) != expect {
+				t.Fatalf("expected %q but got %q", expect, buf.Bytes())
+			}
+		})
+	}
+}
+
+func TestBuffer_NewLogger(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 64), 0, 64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := buf.NewLogger("app: ", 0)
+	for i := 0; i < 20; i++ {
+		logger.Printf("event %d", i)
+	}
+
+	if buf.TotalWritten() <= buf.Size() {
+		t.Fatalf("expected more than %d bytes to be logged", buf.Size())
+	}
+	expect := "app: event 17\napp: event 18\napp: event 19\n"
+	if !bytes.HasSuffix(buf.Bytes(), []byte(expect)) {
+		t.Fatalf("expected the retained logs to end with %q but got %q", expect, buf.Bytes())
+	}
+}
+
+func TestBuffer_Contains(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// retains "lo world" with "lo wo" before the wrap and "rld" after it
+	buf.Write([]byte("hello "))
+	buf.Write([]byte("world"))
+
+	testCases := []struct {
+		sub    string
+		expect bool
+	}{
+		{sub: "", expect: true},
+		{sub: "lo w", expect: true},
+		{sub: "rld", expect: true},
+		{sub: "world", expect: true},
+		{sub: "o", expect: true},
+		{sub: "wor", expect: true},
+		{sub: "orl", expect: true},
+		{sub: "lo world", expect: true},
+		{sub: "hello", expect: false},
+		{sub: "word", expect: false},
+		{sub: "lo world!", expect: false},
+	}
+	for _, tt := range testCases {
+		if got := buf.Contains([]byte(tt.sub)); got != tt.expect {
+			t.Fatalf("expected Contains(%q) to be %t", tt.sub, tt.expect)
+		}
+		if got := bytes.Contains(buf.Bytes(), []byte(tt.sub)); got != tt.expect {
+			t.Fatalf("expected bytes.Contains(%q) to be %t", tt.sub, tt.expect)
+		}
+	}
+
+	sub := []byte("world")
+	if allocs := testing.AllocsPerRun(10, func() { buf.Contains(sub) }); allocs != 0 {
+		t.Fatalf("expected no allocation but got %v", allocs)
+	}
+}
+
+func TestBuffer_SeekToOldest(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+	}{
+		{name: "not wrapped", inputs: []string{"hello"}},
+		{name: "wrapped", inputs: []string{"hello ", "world"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			buf.SetEOFOnDrain(true)
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			first, _ := io.ReadAll(buf)
+			if !bytes.Equal(first, buf.Bytes()) {
+				t.Fatalf("expected %q but got %q", buf.Bytes(), first)
+			}
+
+			buf.SeekToOldest()
+			second, _ := io.ReadAll(buf)
+			if !bytes.Equal(first, second) {
+				t.Fatalf("expected to read %q again but got %q", first, second)
+			}
+			if buf.TotalWritten() != int64(len(strings.Join(tt.inputs, ""))) {
+				t.Fatalf("the written data was modified")
+			}
+		})
+	}
+}
+
+func TestBuffer_WrittenOverflow(t *testing.T) {
+	m := make([]byte, 8)
+	copy(m, "rldlo wo")
+	buf, err := circbuf.NewBufferFromExisting(m, 0, 8, 3, math.MaxInt64-2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(buf.Bytes()) != "lo world" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := buf.Write([]byte("hello world")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(buf.Bytes()) != "lo world" {
+			t.Fatalf("bad: %q", buf.Bytes())
+		}
+	}
+	buf.Write([]byte("!"))
+	if string(buf.Bytes()) != "o world!" {
+		t.Fatalf("bad: %q", buf.Bytes())
+	}
+
+	if buf.TotalWritten() != math.MaxInt64 {
+		t.Fatalf("expected the total to saturate but got %d", buf.TotalWritten())
+	}
+	if seq := buf.OldestSeq(); seq != math.MaxInt64-8 {
+		t.Fatalf("bad oldest sequence number: %d", seq)
+	}
+	if s := buf.State(); s.Written < s.Size || s.Written >= 2*s.Size {
+		t.Fatalf("expected the written count to stay within [%d, %d) but got %d", s.Size, 2*s.Size, s.Written)
+	}
+	if err := buf.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	f, m := createTestMmap(t, t.Name(), 4+5+8+3)
+	defer func() {
+		m.Unmap()
+		f.Close()
+		os.Remove(t.Name() + "_testfile")
+	}()
+	copy(m, "HEAD")
+
+	bufs, err := circbuf.Split(m, 4, 5, 8, 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(bufs) != 3 {
+		t.Fatalf("expected 3 buffers but got %d", len(bufs))
+	}
+
+	inputs := []string{"first ring", "second ring", "third ring"}
+	expect := []string{" ring", "ond ring", "ing"}
+	offsets := []int64{4, 9, 17}
+	for round := 0; round < 3; round++ {
+		for i, buf := range bufs {
+			if _, err := buf.Write([]byte(inputs[i])); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	for i, buf := range bufs {
+		if string(buf.Bytes()) != expect[i] {
+			t.Fatalf("expected ring %d to hold %q but got %q", i, expect[i], buf.Bytes())
+		}
+		if buf.Offset() != offsets[i] {
+			t.Fatalf("expected ring %d to start at %d but got %d", i, offsets[i], buf.Offset())
+		}
+	}
+	if string(m[:4]) != "HEAD" {
+		t.Fatalf("the header was modified: %q", m[:4])
+	}
+
+	if _, err := circbuf.Split(m, 4, 5, 8, 4); err == nil {
+		t.Fatal("expected an error for rings not fitting in the slice")
+	}
+	if _, err := circbuf.Split(m, 4, 5, 0); err == nil {
+		t.Fatal("expected an error for an empty ring")
+	}
+}
+
+func TestBuffer_Lines(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+		expect []string
+	}{
+		{name: "empty"},
+		{name: "no complete line", inputs: []string{"hello"}},
+		{name: "not wrapped", inputs: []string{"one\ntwo\n"}, expect: []string{"one", "two"}},
+		{name: "not wrapped, trailing partial line", inputs: []string{"one\ntwo\nth"}, expect: []string{"one", "two"}},
+		{name: "empty lines", inputs: []string{"one\n\ntwo\n"}, expect: []string{"one", "", "two"}},
+		{name: "wrapped", inputs: []string{"zero\none\n", "two\nthree\n"}, expect: []string{"one", "two", "three"}},
+		{name: "wrapped, trailing partial line", inputs: []string{"zero\none\n", "two\nthree\nfo"}, expect: []string{"two", "three"}},
+		{name: "wrapped on a line boundary", inputs: []string{"x\n", "one\ntwo\nthreeee\n"}, expect: []string{"two", "threeee"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 2+16), 2, 16)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			lines := buf.Lines()
+			if len(lines) != len(tt.expect) {
+				t.Fatalf("expected %q but got %q", tt.expect, lines)
+			}
+			for i := range lines {
+				if string(lines[i]) != tt.expect[i] {
+					t.Fatalf("expected %q but got %q", tt.expect, lines)
+				}
+			}
+		})
+	}
+}
+
+func TestBuffer_LineAligned(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+		expect string
+	}{
+		{name: "not wrapped", inputs: []string{"one\ntw"}, expect: "one\ntw"},
+		{name: "wrapped", inputs: []string{"zero\none\n", "two\nthree\n"}, expect: "one\ntwo\nthree\n"},
+		{name: "wrapped, partial line in the head", inputs: []string{"zero one two thr", "ee\nfour"}, expect: "four"},
+		{name: "wrapped on a line boundary", inputs: []string{"zero\none\ntwo\n", "six\n", "seven\n", "eight\n"}, expect: "seven\neight\n"},
+		{name: "single partial line", inputs: []string{"zero\none\n", "twoooooooooooooo"}, expect: ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBufferLineAligned(make([]byte, 2+16), 2, 16)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			if s := string(buf.Bytes()); s != tt.expect {
+				t.Fatalf("bad: %q", s)
+			}
+			if l := buf.Len(); l != int64(len(tt.expect)) {
+				t.Fatalf("bad: %v", l)
+			}
+			buf.SetEOFOnDrain(true)
+			var out bytes.Buffer
+			if _, err := io.Copy(&out, buf); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if s := out.String(); s != tt.expect {
+				t.Fatalf("bad: %q", s)
+			}
+		})
+	}
+}
+
+func TestCopyN(t *testing.T) {
+	src, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dst, err := circbuf.NewBuffer(make([]byte, 2+5), 2, 5)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	src.Write([]byte("abcdefghijkl"))
+
+	n, err := circbuf.CopyN(dst, src, 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(dst.Bytes()); s != "efg" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	// The rest of src overwrites the oldest bytes of dst
+	n, err = circbuf.CopyN(dst, src, 10)
+	if err != io.EOF {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(dst.Bytes()); s != "hijkl" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	n, err = circbuf.CopyN(dst, src, 1)
+	if err != io.EOF || n != 0 {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if _, err := circbuf.CopyN(dst, dst, 1); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBuffer_ReadFull(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+6), 2, 6)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out := make([]byte, 4)
+	if n, err := buf.ReadFull(out); n != 0 || err != io.EOF {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+
+	buf.Write([]byte("abcdefgh"))
+	n, err := buf.ReadFull(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 4 || string(out) != "cdef" {
+		t.Fatalf("bad: %v %q", n, out)
+	}
+
+	n, err = buf.ReadFull(out)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 2 || string(out[:n]) != "gh" {
+		t.Fatalf("bad: %v %q", n, out[:n])
+	}
+
+	if n, err := buf.ReadFull(out); n != 0 || err != io.EOF {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if n, err := buf.ReadFull(nil); n != 0 || err != nil {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+}
+
+func TestBuffer_SwapBacking(t *testing.T) {
+	old := make([]byte, 2+6)
+	buf, err := circbuf.NewBuffer(old, 2, 6)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("abcdefgh"))
+	buf.MarkSynced()
+
+	if err := buf.SwapBacking(make([]byte, 7)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if s := string(buf.Bytes()); s != "cdefgh" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	grown := make([]byte, 64)
+	if err := buf.SwapBacking(grown); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !buf.Dirty() {
+		t.Fatalf("expected the buffer to be dirty")
+	}
+	if s := string(buf.Bytes()); s != "cdefgh" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	buf.Write([]byte("ij"))
+	if s := string(buf.Bytes()); s != "efghij" {
+		t.Fatalf("bad: %q", s)
+	}
+	if s := string(grown[2:8]); s != "ijefgh" {
+		t.Fatalf("bad: %q", s)
+	}
+	if s := string(old[2:]); s != "cdefgh" {
+		t.Fatalf("old backing modified: %q", s)
+	}
+}
+
+func TestBuffer_Count(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := buf.Count('\n'); n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	buf.Write([]byte("a\nb\n"))
+	if n := buf.Count('\n'); n != 2 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	// Wrap so line feeds are found on both sides of the boundary
+	buf.Write([]byte("c\nd\ne\n"))
+	if s := string(buf.Bytes()); s != "b\nc\nd\ne\n" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := buf.Count('\n'); n != 4 {
+		t.Fatalf("bad: %v", n)
+	}
+	if n := buf.Count('x'); n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+}
+
+func TestBuffer_Cap(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 32), 4, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c := buf.Cap(); c != 28 {
+		t.Fatalf("bad: %v", c)
+	}
+	if s := buf.Size(); s != 16 {
+		t.Fatalf("bad: %v", s)
+	}
+
+	buf, err = circbuf.NewBufferFull(make([]byte, 32), 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c, s := buf.Cap(), buf.Size(); c != s {
+		t.Fatalf("bad: %v %v", c, s)
+	}
+}
+
+func TestBuffer_HashInto(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 3+10), 3, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, in := range []string{"", "hello", " world, wrapped"} {
+		buf.Write([]byte(in))
+
+		h := sha256.New()
+		buf.HashInto(h)
+		want := sha256.Sum256(buf.Bytes())
+		if !bytes.Equal(h.Sum(nil), want[:]) {
+			t.Fatalf("bad sum after writing %q", in)
+		}
+	}
+}
+
+func TestBuffer_HasSeq(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 4), 0, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if buf.HasSeq(0) {
+		t.Fatalf("nothing written yet")
+	}
+
+	buf.Write([]byte("abc"))
+	for seq, want := range []bool{true, true, true, false} {
+		if got := buf.HasSeq(int64(seq)); got != want {
+			t.Fatalf("bad: %v %v", seq, got)
+		}
+	}
+
+	// Overflow, only sequences 6 to 9 are retained
+	buf.Write([]byte("defghij"))
+	for _, seq := range []int64{-1, 0, 5, 10} {
+		if buf.HasSeq(seq) {
+			t.Fatalf("bad: %v", seq)
+		}
+	}
+	for seq := int64(6); seq < 10; seq++ {
+		if !buf.HasSeq(seq) {
+			t.Fatalf("bad: %v", seq)
+		}
+	}
+}
+
+func TestBuffer_Protect(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("head"))
+	buf.Protect(4)
+
+	// Filling up the free space is fine
+	if n, err := buf.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if n, err := buf.Write([]byte("de")); n != 0 || err != circbuf.ErrProtected {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if n, err := buf.Fill('x', 2); n != 0 || err != circbuf.ErrProtected {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	n, err := buf.WriteReaderN(strings.NewReader("def"), 3)
+	if err != circbuf.ErrProtected {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(buf.Bytes()); s != "headabcd" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	buf.Release()
+	if n, err := buf.Write([]byte("ef")); n != 2 || err != nil {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if s := string(buf.Bytes()); s != "adabcdef" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	buf.Protect(2)
+	if _, err := buf.Write([]byte("g")); err != circbuf.ErrProtected {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Reset()
+	if _, err := buf.Write([]byte("123456789")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBuffer_PercentFull(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, tt := range []struct {
+		input  string
+		expect float64
+	}{
+		{"", 0},
+		{"abcd", 50},
+		{"efgh", 100},
+		{"ijkl", 100},
+	} {
+		buf.Write([]byte(tt.input))
+		if p := buf.PercentFull(); p != tt.expect {
+			t.Fatalf("bad: %v", p)
+		}
+	}
+
+	var zero circbuf.Buffer
+	if p := zero.PercentFull(); p != 0 {
+		t.Fatalf("bad: %v", p)
+	}
+}
+
+func TestBuffer_SetCumulativeTotal(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+	buf.ResetKeep(3)
+	if n := buf.TotalWritten(); n != 3 {
+		t.Fatalf("bad: %v", n)
+	}
+	buf.Write([]byte("!!"))
+	if n := buf.TotalWritten(); n != 5 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(buf.Bytes()); s != "rld!!" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	buf.SetCumulativeTotal(true)
+	buf.ResetKeep(2)
+	if n := buf.TotalWritten(); n != 5 {
+		t.Fatalf("bad: %v", n)
+	}
+	buf.Write([]byte("abc"))
+	buf.Truncate(1)
+	if n := buf.TotalWritten(); n != 8 {
+		t.Fatalf("bad: %v", n)
+	}
+	buf.Reset()
+	buf.Write([]byte("de"))
+	if n := buf.TotalWritten(); n != 10 {
+		t.Fatalf("bad: %v", n)
+	}
+	if n := buf.OldestSeq(); n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	buf.SetCumulativeTotal(false)
+	if n := buf.TotalWritten(); n != 2 {
+		t.Fatalf("bad: %v", n)
+	}
+}
+
+func TestBuffer_EqualBytes(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+6), 2, 6)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !buf.EqualBytes(nil) {
+		t.Fatalf("expected an empty buffer to equal nil")
+	}
+
+	buf.Write([]byte("abcd"))
+	buf.Write([]byte("efgh"))
+	for _, tt := range []struct {
+		p      string
+		expect bool
+	}{
+		{"cdefgh", true},
+		{"cdefgx", false},
+		{"xdefgh", false},
+		{"cdefg", false},
+		{"cdefghi", false},
+		{"", false},
+	} {
+		if got := buf.EqualBytes([]byte(tt.p)); got != tt.expect {
+			t.Fatalf("bad: %q %v", tt.p, got)
+		}
+	}
+}
+
+func TestBuffer_LastDirtyRange(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 4+10), 4, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if start, end, wrapped := buf.LastDirtyRange(); start != end || wrapped {
+		t.Fatalf("bad: %v %v %v", start, end, wrapped)
+	}
+
+	testCases := []struct {
+		input   string
+		start   int64
+		end     int64
+		wrapped bool
+	}{
+		{"abc", 4, 7, false},
+		{"defghij", 7, 14, false},
+		{"klmn", 4, 8, false},
+		{"opqrstuvw", 8, 7, true},
+		{"", 8, 7, true},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		start, end, wrapped := buf.LastDirtyRange()
+		if start != tt.start || end != tt.end || wrapped != tt.wrapped {
+			t.Fatalf("bad after %q: %v %v %v", tt.input, start, end, wrapped)
+		}
+	}
+}
+
+func TestNewPageAlignedBuffer(t *testing.T) {
+	page := int64(os.Getpagesize())
+	m := make([]byte, 16+3*page)
+	buf, err := circbuf.NewPageAlignedBuffer(m, 16, 2*page+page/2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := buf.Size(); s != 2*page {
+		t.Fatalf("bad: %v", s)
+	}
+
+	if _, err := circbuf.NewPageAlignedBuffer(m, 16, page-1); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBuffer_SetWrapPreamble(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+16), 2, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.SetWrapPreamble(make([]byte, 16)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := buf.SetWrapPreamble([]byte("#v1\n")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	testCases := []struct {
+		input  string
+		expect string
+	}{
+		{"#v1\na=1\nb=2\n", "#v1\na=1\nb=2\n"},
+		{"c=3\n", "#v1\na=1\nb=2\nc=3\n"},
+		{"d=4\n", "#v1\nb=2\nc=3\nd=4\n"},
+		{"e=5\nf=6\n", "#v1\nd=4\ne=5\nf=6\n"},
+		{"0123456789abcdefghij", "#v1\n89abcdefghij"},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		if s := string(buf.Bytes()); s != tt.expect {
+			t.Fatalf("bad after %q: %q", tt.input, s)
+		}
+	}
+}
+
+func TestBuffer_SetWrapPreambleDrain(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 10), 0, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.SetEOFOnDrain(true)
+	if err := buf.SetWrapPreamble([]byte("P:")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("0123456789ab"))
+	if _, err := io.ReadAll(buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the preamble doesn't overwrite unread data
+	buf.Write([]byte("stuvwxyz!"))
+	if s := string(buf.Bytes()); s != "P:tuvwxyz!" {
+		t.Fatalf("bad: %q", s)
+	}
+	out, err := io.ReadAll(buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out) != "tuvwxyz!" {
+		t.Fatalf("bad: %q", out)
+	}
+
+	// the preamble is part of the dirty range
+	buf.Write([]byte("ab"))
+	start, end, wrapped := buf.LastDirtyRange()
+	if start != 9 || end != 3 || !wrapped {
+		t.Fatalf("bad: %d, %d, %v", start, end, wrapped)
+	}
+}
+
+func TestBuffer_NewBytesReader(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+	r := buf.NewBytesReader()
+	buf.Write([]byte("!!!"))
+
+	if n := r.Len(); n != 8 {
+		t.Fatalf("bad: %v", n)
+	}
+	if _, err := r.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(rest) != "rld" {
+		t.Fatalf("bad: %q", rest)
+	}
+
+	p := make([]byte, 4)
+	if _, err := r.ReadAt(p, 1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(p) != "o wo" {
+		t.Fatalf("bad: %q", p)
+	}
+}
+
+func TestBuffer_WriteV(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	n, err := buf.WriteV([]byte("hdr:"), nil, []byte("ab"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(buf.Bytes()); s != "hdr:ab" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	// Wraps, then more than size bytes across the slices
+	testCases := []struct {
+		bufs   []string
+		expect string
+	}{
+		{[]string{"hdr:", "cd"}, "abhdr:cd"},
+		{[]string{"hdr:", "0123", "456789"}, "23456789"},
+		{[]string{"0123456789", "ab"}, "456789ab"},
+		{[]string{"hdr:", "0123456789abcdef"}, "89abcdef"},
+	}
+	for _, tt := range testCases {
+		var bufs [][]byte
+		var size int
+		for _, b := range tt.bufs {
+			bufs = append(bufs, []byte(b))
+			size += len(b)
+		}
+		n, err := buf.WriteV(bufs...)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != size {
+			t.Fatalf("bad: %v", n)
+		}
+		if s := string(buf.Bytes()); s != tt.expect {
+			t.Fatalf("bad: %q", s)
+		}
+	}
+	if tw := buf.TotalWritten(); tw != 58 {
+		t.Fatalf("bad: %v", tw)
+	}
+}
+
+func TestBuffer_SetSize(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+16), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.SetSize(17); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := buf.SetSize(0); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	buf.Write([]byte("hello world"))
+	buf.ReadN(5)
+
+	// Shrink, keeping the newest bytes
+	if err := buf.SetSize(4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(buf.Bytes()); s != "orld" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := buf.TotalWritten(); n != 11 {
+		t.Fatalf("bad: %v", n)
+	}
+	p, _ := buf.ReadN(10)
+	if string(p) != "rld" {
+		t.Fatalf("bad: %q", p)
+	}
+	buf.Write([]byte("!"))
+	if s := string(buf.Bytes()); s != "rld!" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	// Grow within the capacity
+	if err := buf.SetSize(16); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(buf.Bytes()); s != "rld!" {
+		t.Fatalf("bad: %q", s)
+	}
+	buf.Write([]byte(" and more"))
+	if s := string(buf.Bytes()); s != "rld! and more" {
+		t.Fatalf("bad: %q", s)
+	}
+	buf.Write([]byte("....."))
+	if s := string(buf.Bytes()); s != "d! and more....." {
+		t.Fatalf("bad: %q", s)
+	}
+	if s := buf.Size(); s != 16 {
+		t.Fatalf("bad: %v", s)
+	}
+}
+
+func TestBuffer_SetSizeRead(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("abcdefgh"))
+	if err := buf.SetSize(4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the default Read doesn't go past the shrunk ring
+	out := make([]byte, 6)
+	n, err := buf.Read(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "efghef" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+}
+
+func TestBuffer_SetSizeGrowRoundTrip(t *testing.T) {
+	m := make([]byte, circbuf.HeaderSize+20)
+	buf, err := circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("0123456789abcde"))
+	if err := buf.SetSize(20); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(buf.Bytes()); s != "56789abcde" {
+		t.Fatalf("bad: %q", s)
+	}
+	// the counters restart from the kept bytes
+	if n := buf.TotalWritten(); n != 10 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	restored, err := circbuf.RestoreSnapshot(buf.Snapshot(), make([]byte, 20), 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(restored.Bytes()); s != "56789abcde" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	reopened, err := circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 20)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "56789abcde" {
+		t.Fatalf("bad: %q", s)
+	}
+	reopened.Write([]byte("fghijklmno!"))
+	if s := string(reopened.Bytes()); s != "6789abcdefghijklmno!" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestBuffer_Backing(t *testing.T) {
+	m := make([]byte, 4+8, 32)
+	buf, err := circbuf.NewBuffer(m, 4, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello"))
+
+	backing := buf.Backing()
+	if len(backing) != len(m) || cap(backing) != cap(m) || &backing[0] != &m[0] {
+		t.Fatalf("expected the slice passed to NewBuffer")
+	}
+	if s := string(backing[4:9]); s != "hello" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestBuffer_WriteLine(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+12), 2, 12)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCases := []struct {
+		line   string
+		expect string
+	}{
+		{"first", "first\n"},
+		{"", "first\n\n"},
+		{"second", "rst\n\nsecond\n"},
+		{"a very long line", "y long line\n"},
+	}
+	for _, tt := range testCases {
+		n, err := buf.WriteLine([]byte(tt.line))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != len(tt.line)+1 {
+			t.Fatalf("bad: %v", n)
+		}
+		if s := string(buf.Bytes()); s != tt.expect {
+			t.Fatalf("bad: %q", s)
+		}
+	}
+}
+
+func TestBuffer_Trailer(t *testing.T) {
+	m := make([]byte, 2+8+4)
+	copy(m, "HD")
+	buf, err := circbuf.NewBuffer(m, 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(buf.Header()); s != "HD" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := len(buf.Trailer()); n != 4 {
+		t.Fatalf("bad: %v", n)
+	}
+	if err := buf.WriteTrailer([]byte("12345")); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	if err := buf.WriteTrailer([]byte("TR")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, in := range []string{"hello", " world", ", wrapping"} {
+		buf.Write([]byte(in))
+		if s := string(buf.Trailer()); s != "TR\x00\x00" {
+			t.Fatalf("bad: %q", s)
+		}
+	}
+	if s := string(buf.Bytes()); s != "wrapping" {
+		t.Fatalf("bad: %q", s)
+	}
+	if s := string(m[:2]); s != "HD" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	full, err := circbuf.NewBufferFull(make([]byte, 8), 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if tr := full.Trailer(); len(tr) != 0 {
+		t.Fatalf("bad: %q", tr)
+	}
+	if err := full.WriteTrailer(nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBuffer_Finalize(t *testing.T) {
+	testCases := []struct {
+		name        string
+		lineAligned bool
+		inputs      []string
+		expect      string
+		after       string
+	}{
+		{name: "empty", expect: "", after: "z"},
+		{name: "not wrapped", inputs: []string{"abc"}, expect: "abc", after: "abcz"},
+		{name: "wrapped", inputs: []string{"abcdef", "ghijk"}, expect: "defghijk", after: "efghijkz"},
+		{name: "line aligned", lineAligned: true, inputs: []string{"ab\ncd\n", "ef\ngh"}, expect: "ef\ngh", after: "ef\nghz"},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			m := make([]byte, 2+8)
+			newBuffer := circbuf.NewBuffer
+			if tt.lineAligned {
+				newBuffer = circbuf.NewBufferLineAligned
+			}
+			buf, err := newBuffer(m, 2, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for i := range m {
+				m[i] = 'x'
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+			before := string(buf.Bytes())
+			if before != tt.expect {
+				t.Fatalf("bad: %q", before)
+			}
+
+			buf.Finalize()
+			if s := string(buf.Bytes()); s != before {
+				t.Fatalf("bad: %q", s)
+			}
+			l := buf.Len()
+			if wc := buf.State().WriteCursor; wc != l%8 {
+				t.Fatalf("bad: %v", wc)
+			}
+			// The retained data starts the ring, followed by zeroes
+			ring := m[2:]
+			if s := string(ring[:l]); s != before {
+				t.Fatalf("bad: %q", s)
+			}
+			for _, c := range ring[l:] {
+				if c != 0 {
+					t.Fatalf("bad: %q", ring)
+				}
+			}
+			if s := string(m[:2]); s != "xx" {
+				t.Fatalf("bad: %q", s)
+			}
+
+			buf.Write([]byte("z"))
+			if s := string(buf.Bytes()); s != tt.after {
+				t.Fatalf("bad: %q", s)
+			}
+		})
+	}
+}
+
+func TestBuffer_IsContiguous(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCases := []struct {
+		input  string
+		expect bool
+	}{
+		{"", true},
+		{"abcd", true},
+		{"efgh", true},
+		{"ij", false},
+		{"klmnop", true},
+		{"abc", false},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		if got := buf.IsContiguous(); got != tt.expect {
+			t.Fatalf("bad after %q: %v", tt.input, got)
+		}
+		// Contiguous data is returned without copying
+		if tt.expect && buf.Len() > 0 && &buf.Bytes()[0] != &buf.Backing()[buf.PhysicalIndex(0)] {
+			t.Fatalf("expected Bytes to alias the ring after %q", tt.input)
+		}
+	}
+}
+
+func TestBuffer_Unread(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+6), 2, 6)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.Unread(1); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	buf.Write([]byte("abcdefgh"))
+	p, _ := buf.ReadN(5)
+	if string(p) != "cdefg" {
+		t.Fatalf("bad: %q", p)
+	}
+
+	// The read position goes back across the wrap boundary
+	if err := buf.Unread(3); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p, _ = buf.ReadN(10)
+	if string(p) != "efgh" {
+		t.Fatalf("bad: %q", p)
+	}
+	if err := buf.Unread(6); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p, _ = buf.ReadN(10)
+	if string(p) != "cdefgh" {
+		t.Fatalf("bad: %q", p)
+	}
+	if err := buf.Unread(7); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := buf.Unread(-1); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBuffer_DrainFilterLines(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+40), 2, 40)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	noDebug := func(line []byte) bool {
+		return !bytes.Contains(line, []byte("DEBUG"))
+	}
+	if lines := buf.DrainFilterLines(noDebug); lines != nil {
+		t.Fatalf("bad: %q", lines)
+	}
+
+	buf.Write([]byte("INFO start\nDEBUG x=1\nWARN low\nINFO pa"))
+	lines := buf.DrainFilterLines(noDebug)
+	if len(lines) != 2 || string(lines[0]) != "INFO start" || string(lines[1]) != "WARN low" {
+		t.Fatalf("bad: %q", lines)
+	}
+
+	// The partial line is completed by the next write
+	buf.Write([]byte("rtial\nDEBUG y=2\n"))
+	lines = buf.DrainFilterLines(noDebug)
+	if len(lines) != 1 || string(lines[0]) != "INFO partial" {
+		t.Fatalf("bad: %q", lines)
+	}
+	if n := buf.Len(); n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+}
+
+func TestBuffer_WriteSection(t *testing.T) {
+	f, err := os.CreateTemp("", "circbuf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if _, err := f.WriteString("0123456789abcdefghijklmnopqrstuvwxyz"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sr := io.NewSectionReader(f, 5, 20)
+	sr.Seek(10, io.SeekStart)
+	n, err := buf.WriteSection(sr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 20 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(buf.Bytes()); s != "hijklmno" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	// The section goes past the end of the file
+	n, err = buf.WriteSection(io.NewSectionReader(f, 30, 10))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(buf.Bytes()); s != "nouvwxyz" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestBuffer_OldestIndex(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 3+8), 3, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCases := []struct {
+		input  string
+		expect int64
+	}{
+		{"", 3},
+		{"abc", 3},
+		{"defgh", 3},
+		{"ij", 5},
+		{"klmnop", 3},
+		{"qrstuvwxyz", 3},
+		{"a", 4},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		i := buf.OldestIndex()
+		if i != tt.expect {
+			t.Fatalf("bad after %q: %v", tt.input, i)
+		}
+		if buf.Len() > 0 && buf.Backing()[i] != buf.Bytes()[0] {
+			t.Fatalf("bad after %q: %q", tt.input, buf.Backing()[i])
+		}
+	}
+}
+
+func TestBuffer_NextWriteSlice(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("abcde"))
+
+	p := buf.NextWriteSlice()
+	if len(p) != 3 {
+		t.Fatalf("bad: %v", len(p))
+	}
+	copy(p, "fgh")
+	if err := buf.Commit(3); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The ring wrapped, the next slice starts at the beginning
+	p = buf.NextWriteSlice()
+	if len(p) != 8 {
+		t.Fatalf("bad: %v", len(p))
+	}
+	copy(p, "ij")
+	if err := buf.Commit(2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(buf.Bytes()); s != "cdefghij" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := buf.TotalWritten(); n != 10 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	buf.CloseWrite()
+	if p := buf.NextWriteSlice(); len(p) != 0 {
+		t.Fatalf("bad: %q", p)
+	}
+}
+
+func TestBuffer_Commit(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ref, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.Commit(1); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	for _, in := range []string{"abc", "defgh", "ijklmn", "o"} {
+		p := in
+		for len(p) > 0 {
+			k := copy(buf.NextWriteSlice(), p)
+			if err := buf.Commit(k); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			p = p[k:]
+		}
+		ref.Write([]byte(in))
+		if s, expect := string(buf.Bytes()), string(ref.Bytes()); s != expect {
+			t.Fatalf("expected %q but got %q", expect, s)
+		}
+	}
+
+	p := buf.NextWriteSlice()
+	if err := buf.Commit(len(p) + 1); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := buf.Commit(-1); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := buf.Commit(1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// The slice was already committed
+	if err := buf.Commit(1); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	buf.NextWriteSlice()
+	buf.Write([]byte("x"))
+	if err := buf.Commit(1); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	copy(buf.NextWriteSlice(), "yz!")
+	total := buf.TotalWritten()
+	buf.CloseWrite()
+	if err := buf.Commit(3); err != circbuf.ErrWriteClosed {
+		t.Fatalf("expected ErrWriteClosed but got %v", err)
+	}
+	if n := buf.TotalWritten(); n != total {
+		t.Fatalf("bad: %v", n)
+	}
+}
+
+func TestBuffer_ReadV(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	hdr, body := make([]byte, 3), make([]byte, 4)
+	if n, err := buf.ReadV(hdr, body); n != 0 || err != io.EOF {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+
+	buf.Write([]byte("0123456789"))
+	n, err := buf.ReadV(hdr, body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 7 || string(hdr) != "234" || string(body) != "5678" {
+		t.Fatalf("bad: %v %q %q", n, hdr, body)
+	}
+
+	// Short data, the second slice is partially filled
+	buf.Write([]byte("abcd"))
+	n, err = buf.ReadV(hdr, body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 5 || string(hdr) != "9ab" || string(body[:2]) != "cd" {
+		t.Fatalf("bad: %v %q %q", n, hdr, body[:2])
+	}
+	if n, err := buf.ReadV(nil, []byte{}); n != 0 || err != nil {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+}
+
+func TestBuffer_LineCount(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+10), 2, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCases := []struct {
+		input  string
+		expect int
+	}{
+		{"", 0},
+		{"one\ntwo\n", 2},
+		{"three\n", 2},
+		{"fo", 2},
+		{"ur\nfive\nsix", 2},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		if n := buf.LineCount(); n != tt.expect {
+			t.Fatalf("bad after %q: %v (%q)", tt.input, n, buf.Bytes())
+		}
+	}
+}
+
+func TestBuffer_Zero(t *testing.T) {
+	m := make([]byte, 2+8+2)
+	copy(m, "HD")
+	copy(m[10:], "TR")
+	buf, err := circbuf.NewBuffer(m, 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("secret password"))
+	buf.Zero()
+
+	if n := buf.Len(); n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+	for _, c := range m[2:10] {
+		if c != 0 {
+			t.Fatalf("bad: %q", m)
+		}
+	}
+	if s := string(m[:2]) + string(m[10:]); s != "HDTR" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestBuffer_Chunks(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if chunks := buf.Chunks(4); chunks != nil {
+		t.Fatalf("bad: %q", chunks)
+	}
+	buf.Write([]byte("abcdefghij"))
+
+	testCases := []struct {
+		size   int
+		expect []string
+	}{
+		{4, []string{"cdef", "ghij"}},
+		{3, []string{"cde", "fgh", "ij"}},
+		{8, []string{"cdefghij"}},
+		{20, []string{"cdefghij"}},
+		{0, nil},
+	}
+	for _, tt := range testCases {
+		chunks := buf.Chunks(tt.size)
+		if len(chunks) != len(tt.expect) {
+			t.Fatalf("bad: %q", chunks)
+		}
+		for i := range chunks {
+			if string(chunks[i]) != tt.expect[i] {
+				t.Fatalf("bad: %q", chunks)
+			}
+		}
+	}
+}
+
+func TestBuffer_ReadWithGap(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out := make([]byte, 4)
+	if n, gap, err := buf.ReadWithGap(out); n != 0 || gap != 0 || err != io.EOF {
+		t.Fatalf("bad: %v %v %v", n, gap, err)
+	}
+
+	buf.Write([]byte("abcdef"))
+	n, gap, err := buf.ReadWithGap(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "abcd" || gap != 0 {
+		t.Fatalf("bad: %q %v", out[:n], gap)
+	}
+
+	// The writer overtakes the reader, "ef" and "ghi" are lost
+	buf.Write([]byte("ghijklmnopq"))
+	n, gap, err = buf.ReadWithGap(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "jklm" || gap != 5 {
+		t.Fatalf("bad: %q %v", out[:n], gap)
+	}
+
+	n, gap, err = buf.ReadWithGap(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "nopq" || gap != 0 {
+		t.Fatalf("bad: %q %v", out[:n], gap)
+	}
+}
+
+func TestBuffer_SetGrowable(t *testing.T) {
+	if _, err := circbuf.NewSizedBuffer(0); err == nil {
+		t.Fatalf("expected an error")
+	}
+	mapped, err := circbuf.NewBuffer(make([]byte, 4), 0, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := mapped.SetGrowable(16); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	buf, err := circbuf.NewSizedBuffer(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.SetGrowable(2); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := buf.SetGrowable(12); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	testCases := []struct {
+		input  string
+		size   int64
+		expect string
+	}{
+		{"abc", 4, "abc"},
+		{"de", 8, "abcde"},
+		{"fgh", 8, "abcdefgh"},
+		{"ijk", 12, "abcdefghijk"},
+		{"lmn", 12, "cdefghijklmn"},
+		{"0123456789abcdef", 12, "456789abcdef"},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		if s := buf.Size(); s != tt.size {
+			t.Fatalf("bad size after %q: %v", tt.input, s)
+		}
+		if s := string(buf.Bytes()); s != tt.expect {
+			t.Fatalf("bad after %q: %q", tt.input, s)
+		}
+	}
+
+	// The read position survives the growth
+	small, err := circbuf.NewSizedBuffer(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	small.SetGrowable(64)
+	small.Write([]byte("abcd"))
+	small.ReadN(3)
+	small.Write([]byte("efgh"))
+	if p, _ := small.ReadN(10); string(p) != "defgh" {
+		t.Fatalf("bad: %q", p)
+	}
+}
+
+func TestBuffer_SetGrowableProtected(t *testing.T) {
+	buf, err := circbuf.NewSizedBuffer(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.SetGrowable(16)
+	buf.Write([]byte("ab"))
+	buf.Protect(2)
+
+	// the ring grows instead of overwriting the protected bytes
+	for _, write := range []func() (int, error){
+		func() (int, error) { return buf.Write([]byte("cdef")) },
+		func() (int, error) { return buf.WriteV([]byte("gh"), []byte("ij")) },
+		func() (int, error) { return buf.Fill('k', 4) },
+	} {
+		if _, err := write(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if s := string(buf.Bytes()); s != "abcdefghijkkkk" {
+		t.Fatalf("bad: %q", s)
+	}
+	if s := buf.Size(); s != 16 {
+		t.Fatalf("bad: %v", s)
+	}
+
+	// once it can't grow anymore, the protection applies
+	if _, err := buf.Write([]byte("lmn")); err != circbuf.ErrProtected {
+		t.Fatalf("expected ErrProtected but got %v", err)
+	}
+}
+
+func TestBuffer_CommonSuffixLen(t *testing.T) {
+	newBuf := func(size int64, inputs ...string) *circbuf.Buffer {
+		buf, err := circbuf.NewBuffer(make([]byte, 2+size), 2, size)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, in := range inputs {
+			buf.Write([]byte(in))
+		}
+		return buf
+	}
+	testCases := []struct {
+		name   string
+		a, b   *circbuf.Buffer
+		expect int64
+	}{
+		{"empty", newBuf(8), newBuf(8, "abc"), 0},
+		{"no overlap", newBuf(8, "abc"), newBuf(8, "abd"), 0},
+		{"partial overlap", newBuf(8, "xxx", "defgh"), newBuf(6, "abc", "yfgh"), 3},
+		{"full overlap of the shorter", newBuf(8, "abcdef", "ghij"), newBuf(4, "zz", "ghij"), 4},
+		{"identical", newBuf(6, "012345", "6789"), newBuf(6, "456789"), 6},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := tt.a.CommonSuffixLen(tt.b); n != tt.expect {
+				t.Fatalf("bad: %v", n)
+			}
+			if n := tt.b.CommonSuffixLen(tt.a); n != tt.expect {
+				t.Fatalf("bad: %v", n)
+			}
+		})
+	}
+}
+
+func TestBuffer_GzipWriter(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+1024), 2, 1024)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	text := strings.Repeat("the same log line, over and over\n", 100)
+	zw := buf.GzipWriter()
+	if _, err := io.WriteString(zw, text); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The ring holds the compressed bytes
+	if l := buf.Len(); l == 0 || l >= int64(len(text)) {
+		t.Fatalf("bad: %v", l)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out) != text {
+		t.Fatalf("bad: %q", out)
+	}
+}
+
+func TestBuffer_WriteTempFile(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello "))
+	buf.Write([]byte("world"))
+
+	name, err := buf.WriteTempFile("", "circbuf-*.log")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(name)
+	if !strings.HasSuffix(name, ".log") {
+		t.Fatalf("bad: %v", name)
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(content, buf.Bytes()) {
+		t.Fatalf("bad: %q", content)
+	}
+
+	if _, err := buf.WriteTempFile(string([]byte{0}), "x"); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBuffer_SetWrapHook(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+4), 2, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var counts []int64
+	buf.SetWrapHook(func(wrapCount int64) {
+		counts = append(counts, wrapCount)
+	})
+
+	testCases := []struct {
+		input string
+		wraps int
+	}{
+		{"ab", 0},
+		{"c", 0},
+		{"d", 1},
+		{"efg", 1},
+		{"hi", 2},
+		{"", 2},
+		{"jklmnopq", 3},
+		{"r", 3},
+	}
+	for _, tt := range testCases {
+		buf.Write([]byte(tt.input))
+		if len(counts) != tt.wraps {
+			t.Fatalf("bad after %q: %v", tt.input, counts)
+		}
+	}
+	for i, c := range counts {
+		if c != int64(i+1) {
+			t.Fatalf("bad: %v", counts)
+		}
+	}
+
+	buf.SetWrapHook(nil)
+	buf.Write([]byte("stu"))
+	if len(counts) != 3 {
+		t.Fatalf("bad: %v", counts)
+	}
+}
@@ -0,0 +1,136 @@
+package circbuf
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	errWhence    = errors.New("circbuf: invalid whence")
+	errSeekRange = errors.New("circbuf: seek position out of range")
+)
+
+// Cursor is an independent read position over the data retained by a
+// Buffer. Several cursors can read the same buffer, each at its own pace,
+// without moving the read position of the buffer itself.
+// If the data a cursor points to gets overwritten, the cursor moves to the
+// oldest retained byte.
+type Cursor struct {
+	b *Buffer
+	// sb guards b for the cursors of a Broadcaster
+	sb *SyncBuffer
+	// pos is the position in the stream of written bytes
+	pos int64
+}
+
+// NewCursor returns a cursor positioned on the oldest retained byte.
+func (b *Buffer) NewCursor() *Cursor {
+	return &Cursor{b: b, pos: b.OldestSeq()}
+}
+
+// Reader returns a reader going through the retained data, oldest byte
+// first, which returns io.EOF once all of it was read. The reader uses its
+// own Cursor so it doesn't move the read position of the buffer.
+func (b *Buffer) Reader() io.Reader {
+	return b.NewCursor()
+}
+
+// Read reads up to len(out) bytes not read yet by this cursor into out.
+// It returns io.EOF once all the retained data was read.
+func (c *Cursor) Read(out []byte) (int, error) {
+	defer c.lock()()
+	return c.read(out)
+}
+
+// ReadContext reads like Read does but, for the cursors of a Broadcaster,
+// blocks until data is written or ctx is done, in which case ctx.Err() is
+// returned, instead of returning io.EOF. io.EOF is only returned once the
+// buffer is closed for writing.
+func (c *Cursor) ReadContext(ctx context.Context, out []byte) (int, error) {
+	if c.sb == nil || len(out) == 0 {
+		return c.Read(out)
+	}
+	defer c.sb.wakeOnDone(ctx)()
+
+	c.sb.mu.Lock()
+	defer c.sb.mu.Unlock()
+	for c.logical() == c.b.Len() {
+		if c.b.writeClosed {
+			return 0, io.EOF
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		c.sb.cond.Wait()
+	}
+	return c.read(out)
+}
+
+func (c *Cursor) read(out []byte) (int, error) {
+	logical := c.logical()
+	if logical == c.b.Len() {
+		if len(out) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := c.b.copyAt(out, logical)
+	c.pos += int64(n)
+	return n, nil
+}
+
+// Peek returns a copy of the next n bytes without moving the cursor.
+// If fewer than n bytes are available, they are returned along with io.EOF.
+func (c *Cursor) Peek(n int) ([]byte, error) {
+	defer c.lock()()
+	logical := c.logical()
+	if avail := c.b.Len() - logical; int64(n) > avail {
+		out := make([]byte, avail)
+		c.b.copyAt(out, logical)
+		return out, io.EOF
+	}
+	out := make([]byte, n)
+	c.b.copyAt(out, logical)
+	return out, nil
+}
+
+// Seek sets the position of the cursor within the retained data, 0 being
+// the oldest retained byte, and returns the new position.
+func (c *Cursor) Seek(offset int64, whence int) (int64, error) {
+	defer c.lock()()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.logical()
+	case io.SeekEnd:
+		offset += c.b.Len()
+	default:
+		return 0, errWhence
+	}
+	if offset < 0 || offset > c.b.Len() {
+		return 0, errSeekRange
+	}
+	c.pos = c.b.OldestSeq() + offset
+	return offset, nil
+}
+
+// lock locks the buffer of a Broadcaster cursor and returns the function
+// unlocking it.
+func (c *Cursor) lock() (unlock func()) {
+	if c.sb == nil {
+		return func() {}
+	}
+	c.sb.mu.Lock()
+	return c.sb.mu.Unlock
+}
+
+// logical returns the position of the cursor within the retained data,
+// moving the cursor if the data it pointed to is gone.
+func (c *Cursor) logical() int64 {
+	start := c.b.OldestSeq()
+	if c.pos < start || c.pos > c.b.total {
+		c.pos = start
+	}
+	return c.pos - start
+}
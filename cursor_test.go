@@ -0,0 +1,166 @@
+package circbuf_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestCursor_Impl(t *testing.T) {
+	var _ io.ReadSeeker = &circbuf.Cursor{}
+}
+
+func TestCursor_IndependentReaders(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello "))
+
+	fast := buf.NewCursor()
+	slow := buf.NewCursor()
+
+	out, err := io.ReadAll(fast)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out) != "hello " {
+		t.Fatalf("bad: %q", out)
+	}
+
+	small := make([]byte, 2)
+	if n, _ := slow.Read(small); n != 2 || string(small) != "he" {
+		t.Fatalf("bad: %q", small[:n])
+	}
+
+	// "he" gets overwritten, the slow cursor jumps to the oldest byte
+	buf.Write([]byte("world"))
+
+	out, _ = io.ReadAll(fast)
+	if string(out) != "world" {
+		t.Fatalf("expected the fast cursor to read %q but got %q", "world", out)
+	}
+	out, _ = io.ReadAll(slow)
+	if string(out) != "lo world" {
+		t.Fatalf("expected the slow cursor to read %q but got %q", "lo world", out)
+	}
+
+	if n, err := fast.Read(small); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF but got %d, %v", n, err)
+	}
+}
+
+func TestCursor_Peek(t *testing.T) {
+	buf, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	buf.Write([]byte("hello world"))
+
+	c := buf.NewCursor()
+	c.Seek(3, io.SeekStart)
+
+	for i := 0; i < 2; i++ {
+		p, err := c.Peek(3)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(p) != "wor" {
+			t.Fatalf("bad: %q", p)
+		}
+	}
+
+	p, err := c.Peek(10)
+	if err != io.EOF {
+		t.Fatalf("expected EOF but got %v", err)
+	}
+	if string(p) != "world" {
+		t.Fatalf("bad: %q", p)
+	}
+}
+
+func TestCursor_Seek(t *testing.T) {
+	buf, _ := circbuf.NewBuffer(make([]byte, 8), 0, 8)
+	buf.Write([]byte("hello world"))
+	c := buf.NewCursor()
+
+	testCases := []struct {
+		name   string
+		offset int64
+		whence int
+		pos    int64
+		expect string
+	}{
+		{name: "start", offset: 0, whence: io.SeekStart, pos: 0, expect: "lo world"},
+		{name: "current", offset: -3, whence: io.SeekCurrent, pos: 5, expect: "rld"},
+		{name: "end", offset: -5, whence: io.SeekEnd, pos: 3, expect: "world"},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := c.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if pos != tt.pos {
+				t.Fatalf("expected position %d but got %d", tt.pos, pos)
+			}
+			out, _ := io.ReadAll(c)
+			if !bytes.Equal(out, []byte(tt.expect)) {
+				t.Fatalf("expected %q but got %q", tt.expect, out)
+			}
+		})
+	}
+
+	if _, err := c.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("expected an error seeking before the oldest byte")
+	}
+	if _, err := c.Seek(1, io.SeekEnd); err == nil {
+		t.Fatal("expected an error seeking after the newest byte")
+	}
+}
+
+func ExampleBuffer_Reader() {
+	buf, _ := circbuf.NewBuffer(make([]byte, 24), 0, 24)
+	buf.Write([]byte("first line\nsecond line\nthird line\nlast line\n"))
+
+	scanner := bufio.NewScanner(buf.Reader())
+	for scanner.Scan() {
+		fmt.Printf("%q\n", scanner.Text())
+	}
+	// Output:
+	// "ne"
+	// "third line"
+	// "last line"
+}
+
+func TestBuffer_ReaderScanner(t *testing.T) {
+	lines := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot"}
+	buf, err := circbuf.NewBuffer(make([]byte, 3+20), 3, 20)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i, line := range lines {
+		fmt.Fprintln(buf, line)
+
+		var got []string
+		scanner := bufio.NewScanner(buf.Reader())
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		retained := strings.Split(strings.TrimSuffix(string(buf.Bytes()), "\n"), "\n")
+		if strings.Join(got, "|") != strings.Join(retained, "|") {
+			t.Fatalf("after %d lines, expected %q but got %q", i+1, retained, got)
+		}
+		// the newest line is always intact, even when it wraps
+		if got[len(got)-1] != line {
+			t.Fatalf("expected the last token to be %q but got %q", line, got[len(got)-1])
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package circbuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrInvalidEvent is returned when reading an event from a frame too short
+// to hold its timestamp.
+var ErrInvalidEvent = errors.New("circbuf: invalid event")
+
+// eventTimeSize is the size of the timestamp starting an event.
+const eventTimeSize = 8
+
+// WriteEvent writes payload as a frame, like WriteFrame does with the default
+// options, starting with t. The event is made of the 4 bytes big endian
+// length of the rest of the event, t as a big endian 64 bits number of
+// nanoseconds since the Unix epoch, and the payload. Keeping the length
+// first lets events be read as regular frames. Events are read back in the
+// order they were written by ReadEvent.
+func (b *Buffer) WriteEvent(t time.Time, payload []byte) error {
+	var ts [eventTimeSize]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(t.UnixNano()))
+	o, _ := frameOptions(nil)
+	return b.writeFrame(o, ts[:], payload)
+}
+
+// ReadEvent reads the event found at the read position and returns its
+// timestamp and a copy of its payload. Errors are the ones of ReadFrame,
+// ErrInvalidEvent is returned if the frame is too short to be an event.
+func (b *Buffer) ReadEvent() (time.Time, []byte, error) {
+	frame, err := b.ReadFrame()
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if len(frame) < eventTimeSize {
+		return time.Time{}, nil, ErrInvalidEvent
+	}
+	nsec := int64(binary.BigEndian.Uint64(frame))
+	return time.Unix(0, nsec), frame[eventTimeSize:], nil
+}
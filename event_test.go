@@ -0,0 +1,74 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestBuffer_WriteEvent(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 64), 0, 64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	start := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	payloads := []string{"started", "", "stopped"}
+	for i, p := range payloads {
+		if err := buf.WriteEvent(start.Add(time.Duration(i)*time.Millisecond), []byte(p)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	for i, p := range payloads {
+		ts, payload, err := buf.ReadEvent()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if want := start.Add(time.Duration(i) * time.Millisecond); !ts.Equal(want) {
+			t.Fatalf("bad: %v", ts)
+		}
+		if string(payload) != p {
+			t.Fatalf("bad: %q", payload)
+		}
+	}
+	if _, _, err := buf.ReadEvent(); err != io.EOF {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := buf.WriteFrame([]byte("short")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, _, err := buf.ReadEvent(); err != circbuf.ErrInvalidEvent {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBuffer_WriteEvent_Format(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 32), 0, 32)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.WriteEvent(time.Unix(0, 0x0102030405060708), []byte("hi")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expect := []byte{0, 0, 0, 10, 1, 2, 3, 4, 5, 6, 7, 8, 'h', 'i'}
+	if !bytes.Equal(buf.Bytes(), expect) {
+		t.Fatalf("expected %v but got %v", expect, buf.Bytes())
+	}
+}
+
+func TestBuffer_WriteEvent_TooLarge(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 16), 0, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.WriteEvent(time.Now(), []byte("12345")); err != circbuf.ErrFrameTooLarge {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.WriteEvent(time.Now(), []byte("1234")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
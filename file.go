@@ -0,0 +1,110 @@
+package circbuf
+
+import (
+	"fmt"
+	"io"
+)
+
+// ReaderWriterAt is implemented by the storage of a FileBuffer, *os.File
+// being the most common one.
+type ReaderWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// FileBuffer is a circular buffer stored in a region of a file, or any
+// other ReaderWriterAt, which is read and written with positioned I/O
+// instead of being memory mapped. It retains the same data as a Buffer of
+// the same size would. The write position lives in memory only.
+type FileBuffer struct {
+	f ReaderWriterAt
+	// offset is the position of the ring in f
+	offset      int64
+	size        int64
+	writeCursor int64
+	// written only grows up to twice the size, enough to know if the
+	// ring wrapped.
+	written int64
+}
+
+// NewFileBuffer sets a new circular buffer of the passed size on the region
+// of f starting at offset. The content of the region is ignored, the buffer
+// starts empty.
+func NewFileBuffer(f ReaderWriterAt, offset, size int64) (*FileBuffer, error) {
+	switch {
+	case offset < 0:
+		return nil, fmt.Errorf("circbuf: invalid offset %d", offset)
+	case size <= 0:
+		return nil, fmt.Errorf("circbuf: invalid size %d", size)
+	}
+	return &FileBuffer{f: f, offset: offset, size: size}, nil
+}
+
+// Write writes up to len(p) bytes to the ring, overriding older data if
+// necessary. On error, the write position isn't moved and the ring might
+// hold a mix of old and new bytes at the written position.
+func (fb *FileBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+
+	// Only the last size bytes are retained
+	if int64(n) > fb.size {
+		p = p[int64(n)-fb.size:]
+	}
+
+	first := p
+	if remain := fb.size - fb.writeCursor; int64(len(first)) > remain {
+		first = p[:remain]
+	}
+	if _, err := fb.f.WriteAt(first, fb.offset+fb.writeCursor); err != nil {
+		return 0, err
+	}
+	if len(first) < len(p) {
+		if _, err := fb.f.WriteAt(p[len(first):], fb.offset); err != nil {
+			return 0, err
+		}
+	}
+
+	fb.writeCursor = (fb.writeCursor + int64(len(p))) % fb.size
+	fb.written += int64(n)
+	if fb.written >= 2*fb.size {
+		fb.written = fb.size + fb.written%fb.size
+	}
+	return n, nil
+}
+
+// Size returns the size of the ring.
+func (fb *FileBuffer) Size() int64 {
+	return fb.size
+}
+
+// Len returns the number of bytes retained by the buffer.
+func (fb *FileBuffer) Len() int64 {
+	if fb.written > fb.size {
+		return fb.size
+	}
+	return fb.written
+}
+
+// Bytes reads the retained bytes, oldest first, from the file.
+func (fb *FileBuffer) Bytes() ([]byte, error) {
+	out := make([]byte, fb.Len())
+	if fb.written < fb.size {
+		_, err := fb.f.ReadAt(out, fb.offset)
+		return out, err
+	}
+	n, err := fb.f.ReadAt(out[:fb.size-fb.writeCursor], fb.offset+fb.writeCursor)
+	if err != nil {
+		return out, err
+	}
+	_, err = fb.f.ReadAt(out[n:], fb.offset)
+	return out, err
+}
+
+// Reset resets the buffer so it has no content. The file isn't modified.
+func (fb *FileBuffer) Reset() {
+	fb.writeCursor = 0
+	fb.written = 0
+}
@@ -0,0 +1,78 @@
+package circbuf_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestFileBuffer(t *testing.T) {
+	f, err := os.CreateTemp("", "circbuf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	header := []byte("HEADER")
+	if _, err := f.Write(header); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	fb, err := circbuf.NewFileBuffer(f, int64(len(header)), 7)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf, err := circbuf.NewBuffer(make([]byte, 7), 0, 7)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	inputs := []string{"", "hel", "lo", " wor", "ld", ", I am a circular buffer!", "x"}
+	for _, in := range inputs {
+		n, err := fb.Write([]byte(in))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != len(in) {
+			t.Fatalf("bad: %v", n)
+		}
+		buf.Write([]byte(in))
+
+		got, err := fb.Bytes()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(got) != string(buf.Bytes()) {
+			t.Fatalf("bad: %q, expected %q", got, buf.Bytes())
+		}
+		if fb.Len() != buf.Len() {
+			t.Fatalf("bad: %v", fb.Len())
+		}
+	}
+
+	// The bytes before the ring are left untouched
+	check := make([]byte, len(header))
+	if _, err := f.ReadAt(check, 0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(check) != string(header) {
+		t.Fatalf("bad: %q", check)
+	}
+
+	fb.Reset()
+	if got, err := fb.Bytes(); err != nil || len(got) != 0 {
+		t.Fatalf("bad: %q %v", got, err)
+	}
+}
+
+func TestNewFileBuffer_Invalid(t *testing.T) {
+	if _, err := circbuf.NewFileBuffer(nil, -1, 8); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, err := circbuf.NewFileBuffer(nil, 0, 0); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
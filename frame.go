@@ -0,0 +1,275 @@
+package circbuf
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	// ErrFrameTooLarge is returned when a frame and its length prefix
+	// can't fit in the buffer.
+	ErrFrameTooLarge = errors.New("circbuf: frame too large")
+	// ErrInvalidFrameOptions is returned when the frame options use an
+	// unsupported prefix size.
+	ErrInvalidFrameOptions = errors.New("circbuf: invalid frame options")
+)
+
+// FrameOptions describes the length prefix written in front of each frame.
+// The zero value uses a 4 bytes big endian prefix. Frames have to be read
+// using the options they were written with.
+type FrameOptions struct {
+	// PrefixSize is the size of the length prefix in bytes, 2, 4 or 8.
+	PrefixSize int
+	// ByteOrder is the byte order used to encode the length prefix.
+	ByteOrder binary.ByteOrder
+}
+
+// frameOptions returns the first passed options with the defaults applied.
+func frameOptions(opts []FrameOptions) (FrameOptions, error) {
+	var o FrameOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.PrefixSize == 0 {
+		o.PrefixSize = 4
+	}
+	if o.ByteOrder == nil {
+		o.ByteOrder = binary.BigEndian
+	}
+	switch o.PrefixSize {
+	case 2, 4, 8:
+		return o, nil
+	default:
+		return o, ErrInvalidFrameOptions
+	}
+}
+
+// maxLen returns the largest payload length the prefix can hold.
+func (o FrameOptions) maxLen() uint64 {
+	return 1<<(8*uint(o.PrefixSize)) - 1
+}
+
+func (o FrameOptions) putLen(prefix []byte, n uint64) {
+	switch o.PrefixSize {
+	case 2:
+		o.ByteOrder.PutUint16(prefix, uint16(n))
+	case 4:
+		o.ByteOrder.PutUint32(prefix, uint32(n))
+	default:
+		o.ByteOrder.PutUint64(prefix, n)
+	}
+}
+
+func (o FrameOptions) len(prefix []byte) uint64 {
+	switch o.PrefixSize {
+	case 2:
+		return uint64(o.ByteOrder.Uint16(prefix))
+	case 4:
+		return uint64(o.ByteOrder.Uint32(prefix))
+	default:
+		return o.ByteOrder.Uint64(prefix)
+	}
+}
+
+// WriteFrame writes p prefixed by its length so it can later be read back
+// as a whole using ReadFrame. Only the first passed options are used.
+// Frames that don't fit in the buffer are rejected with ErrFrameTooLarge.
+func (b *Buffer) WriteFrame(p []byte, opts ...FrameOptions) error {
+	o, err := frameOptions(opts)
+	if err != nil {
+		return err
+	}
+	return b.writeFrame(o, p)
+}
+
+// writeFrame writes the passed parts, one after the other, as the payload
+// of a single frame.
+func (b *Buffer) writeFrame(o FrameOptions, parts ...[]byte) error {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	if uint64(n) > o.maxLen() || int64(o.PrefixSize)+int64(n) > b.size {
+		return ErrFrameTooLarge
+	}
+	var prefix [8]byte
+	o.putLen(prefix[:o.PrefixSize], uint64(n))
+	// A single write so the frame is accepted or rejected as a whole
+	_, err := b.WriteV(append([][]byte{prefix[:o.PrefixSize]}, parts...)...)
+	return err
+}
+
+// ReadFrame reads the frame found at the read position and returns a copy
+// of its payload. io.EOF is returned when there is nothing left to read and
+// io.ErrUnexpectedEOF when only part of a frame is available, in which case
+// nothing is consumed.
+// Frames are only intact as long as the reader keeps up with the writer,
+// overwritten unread data leaves the read position in the middle of a frame.
+func (b *Buffer) ReadFrame(opts ...FrameOptions) ([]byte, error) {
+	n, err := b.PeekFrameLen(opts...)
+	if err != nil {
+		return nil, err
+	}
+	o, _ := frameOptions(opts)
+	if n > b.unread-int64(o.PrefixSize) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	p := make([]byte, n)
+	b.peek(p, int64(o.PrefixSize))
+	b.consume(int64(o.PrefixSize) + n)
+	return p, nil
+}
+
+// PeekFrameLen returns the payload length of the frame found at the read
+// position without consuming anything, so a reader can check the frame
+// size before reading it. io.EOF is returned when there is nothing left to
+// read and io.ErrUnexpectedEOF when the length prefix is incomplete.
+func (b *Buffer) PeekFrameLen(opts ...FrameOptions) (int64, error) {
+	o, err := frameOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	if b.unread == 0 {
+		return 0, io.EOF
+	}
+	var prefix [8]byte
+	if b.peek(prefix[:o.PrefixSize], 0) < o.PrefixSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := o.len(prefix[:o.PrefixSize])
+	if n > uint64(b.size) {
+		return 0, ErrFrameTooLarge
+	}
+	return int64(n), nil
+}
+
+// WriteMarshaler writes the binary form of m as a frame, see WriteFrame.
+func (b *Buffer) WriteMarshaler(m encoding.BinaryMarshaler) error {
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return b.WriteFrame(p)
+}
+
+// ReadUnmarshaler reads the frame found at the read position, see ReadFrame,
+// and decodes it into u. The frame is consumed even if u fails to decode it.
+func (b *Buffer) ReadUnmarshaler(u encoding.BinaryUnmarshaler) error {
+	p, err := b.ReadFrame()
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalBinary(p)
+}
+
+// FrameIter walks the retained frames, oldest first. See Buffer.Frames.
+type FrameIter struct {
+	b     *Buffer
+	o     FrameOptions
+	at    int64
+	frame []byte
+	err   error
+}
+
+// Frames returns an iterator over the frames retained by the buffer, read
+// from the oldest retained byte which has to start a frame, so the oldest
+// frames must not have been partially overwritten. The read position isn't
+// used nor moved. The buffer must not be modified during the iteration.
+// Only the first passed options are used.
+func (b *Buffer) Frames(opts ...FrameOptions) *FrameIter {
+	o, err := frameOptions(opts)
+	return &FrameIter{b: b, o: o, err: err}
+}
+
+// Next moves to the next frame and reports whether there is one. It returns
+// false at the end of the retained data or on error, see Err.
+func (it *FrameIter) Next() bool {
+	it.frame = nil
+	if it.err != nil || it.at >= it.b.Len() {
+		return false
+	}
+	ps := int64(it.o.PrefixSize)
+	var prefix [8]byte
+	if it.b.copyAt(prefix[:ps], it.at) < int(ps) {
+		it.err = io.ErrUnexpectedEOF
+		return false
+	}
+	n := it.o.len(prefix[:ps])
+	start := it.at + ps
+	if n > uint64(it.b.Len()-start) {
+		it.err = io.ErrUnexpectedEOF
+		return false
+	}
+	end := start + int64(n)
+
+	// Alias the frame unless it wraps
+	tail, head := it.b.segments()
+	switch t := int64(len(tail)); {
+	case end <= t:
+		it.frame = tail[start:end:end]
+	case start >= t:
+		it.frame = head[start-t : end-t : end-t]
+	default:
+		it.frame = make([]byte, n)
+		it.b.copyAt(it.frame, start)
+	}
+	it.at = end
+	return true
+}
+
+// Frame returns the payload of the current frame. It aliases the buffer
+// memory, unless the frame wraps around the end of the ring, and is only
+// valid until the buffer is modified.
+func (it *FrameIter) Frame() []byte {
+	return it.frame
+}
+
+// Err returns the error which ended the iteration, if any.
+// io.ErrUnexpectedEOF is returned if the last frame is incomplete.
+func (it *FrameIter) Err() error {
+	return it.err
+}
+
+// WriteFramedTo writes the retained bytes, oldest first, to w prefixed by
+// their length as a big endian uint64, so the receiver knows how many bytes
+// to read, see ReadFramedFrom. It returns the number of bytes written,
+// prefix included. The read position isn't used nor moved.
+func (b *Buffer) WriteFramedTo(w io.Writer) (int64, error) {
+	tail, head := b.segments()
+	var prefix [8]byte
+	binary.BigEndian.PutUint64(prefix[:], uint64(len(tail)+len(head)))
+
+	var total int64
+	for _, p := range [][]byte{prefix[:], tail, head} {
+		n, err := w.Write(p)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadFramedFrom reads data written by WriteFramedTo from r and writes it to
+// the buffer, overriding older data if necessary. It returns the number of
+// bytes read, prefix included. io.ErrUnexpectedEOF is returned if r ends
+// before all the announced bytes were read.
+func (b *Buffer) ReadFramedFrom(r io.Reader) (int64, error) {
+	var prefix [8]byte
+	n, err := io.ReadFull(r, prefix[:])
+	if err != nil {
+		return int64(n), err
+	}
+	size := binary.BigEndian.Uint64(prefix[:])
+	if size > math.MaxInt64 {
+		return int64(n), ErrFrameTooLarge
+	}
+	m, err := b.WriteReaderN(r, int64(size))
+	if err == nil && m < int64(size) {
+		err = io.ErrUnexpectedEOF
+	}
+	return int64(n) + m, err
+}
@@ -0,0 +1,364 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestBuffer_Frames(t *testing.T) {
+	testCases := []struct {
+		name   string
+		opts   []circbuf.FrameOptions
+		prefix []byte
+	}{
+		{name: "default", prefix: []byte{0, 0, 0, 5}},
+		{name: "uint16 big endian", opts: []circbuf.FrameOptions{{PrefixSize: 2, ByteOrder: binary.BigEndian}}, prefix: []byte{0, 5}},
+		{name: "uint16 little endian", opts: []circbuf.FrameOptions{{PrefixSize: 2, ByteOrder: binary.LittleEndian}}, prefix: []byte{5, 0}},
+		{name: "uint32 big endian", opts: []circbuf.FrameOptions{{PrefixSize: 4, ByteOrder: binary.BigEndian}}, prefix: []byte{0, 0, 0, 5}},
+		{name: "uint32 little endian", opts: []circbuf.FrameOptions{{PrefixSize: 4, ByteOrder: binary.LittleEndian}}, prefix: []byte{5, 0, 0, 0}},
+		{name: "uint64 big endian", opts: []circbuf.FrameOptions{{PrefixSize: 8, ByteOrder: binary.BigEndian}}, prefix: []byte{0, 0, 0, 0, 0, 0, 0, 5}},
+		{name: "uint64 little endian", opts: []circbuf.FrameOptions{{PrefixSize: 8, ByteOrder: binary.LittleEndian}}, prefix: []byte{5, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 4+20), 4, 20)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+
+			if err := buf.WriteFrame([]byte("hello"), tt.opts...); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			expect := append(tt.prefix, "hello"...)
+			if !bytes.Equal(buf.Bytes(), expect) {
+				t.Fatalf("expected %v but got %v", expect, buf.Bytes())
+			}
+
+			p, err := buf.ReadFrame(tt.opts...)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if string(p) != "hello" {
+				t.Fatalf("expected %q but got %q", "hello", p)
+			}
+
+			// go around the ring a few times, frames straddle the wrap
+			for _, in := range []string{"world", "frames", "in", "a", "ring", "buffer"} {
+				if err := buf.WriteFrame([]byte(in), tt.opts...); err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				p, err := buf.ReadFrame(tt.opts...)
+				if err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				if string(p) != in {
+					t.Fatalf("expected %q but got %q", in, p)
+				}
+			}
+
+			if _, err := buf.ReadFrame(tt.opts...); err != io.EOF {
+				t.Fatalf("expected EOF but got %v", err)
+			}
+		})
+	}
+}
+
+func TestBuffer_FrameErrors(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 10), 0, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := buf.WriteFrame([]byte("hello world")); err != circbuf.ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge but got %v", err)
+	}
+	if err := buf.WriteFrame([]byte("hello"), circbuf.FrameOptions{PrefixSize: 3}); err != circbuf.ErrInvalidFrameOptions {
+		t.Fatalf("expected ErrInvalidFrameOptions but got %v", err)
+	}
+	if buf.TotalWritten() != 0 {
+		t.Fatalf("rejected frames were written")
+	}
+
+	// a partial frame isn't consumed
+	buf.Write([]byte{0, 0, 0, 4, 'a'})
+	if _, err := buf.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF but got %v", err)
+	}
+	buf.Write([]byte("bcd"))
+	p, err := buf.ReadFrame()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(p) != "abcd" {
+		t.Fatalf("bad: %q", p)
+	}
+}
+
+func TestBuffer_WriteFrameRejected(t *testing.T) {
+	t.Run("max write", func(t *testing.T) {
+		buf, err := circbuf.NewBuffer(make([]byte, 20), 0, 20)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf.SetMaxWrite(8)
+
+		if err := buf.WriteFrame([]byte("0123456789")); err != circbuf.ErrMaxWrite {
+			t.Fatalf("expected ErrMaxWrite but got %v", err)
+		}
+		if buf.TotalWritten() != 0 {
+			t.Fatalf("bad: %d bytes of a rejected frame written", buf.TotalWritten())
+		}
+
+		// the stream is still in sync
+		if err := buf.WriteFrame([]byte("abcd")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		p, err := buf.ReadFrame()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(p) != "abcd" {
+			t.Fatalf("bad: %q", p)
+		}
+	})
+
+	t.Run("protected", func(t *testing.T) {
+		buf, err := circbuf.NewBuffer(make([]byte, 10), 0, 10)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf.SetEOFOnDrain(true)
+		buf.Write([]byte("0123456"))
+		buf.Protect(1)
+
+		// only 3 bytes can be written before reaching the protected one
+		if err := buf.WriteFrame([]byte("ab")); err != circbuf.ErrProtected {
+			t.Fatalf("expected ErrProtected but got %v", err)
+		}
+		if buf.TotalWritten() != 7 {
+			t.Fatalf("bad: %d bytes written", buf.TotalWritten())
+		}
+		if string(buf.Bytes()) != "0123456" {
+			t.Fatalf("bad: %q", buf.Bytes())
+		}
+
+		buf.Release()
+		if _, err := buf.ReadN(7); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := buf.WriteFrame([]byte("ab")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		p, err := buf.ReadFrame()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(p) != "ab" {
+			t.Fatalf("bad: %q", p)
+		}
+	})
+}
+
+func TestBuffer_PeekFrameLen(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+12), 2, 12)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := buf.PeekFrameLen(); err != io.EOF {
+		t.Fatalf("expected EOF but got %v", err)
+	}
+
+	// move the read and write positions so the next
+	// length prefix straddles the wrap boundary
+	buf.Write([]byte("1234567890"))
+	buf.ReadN(10)
+	if err := buf.WriteFrame([]byte("hello")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		n, err := buf.PeekFrameLen()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != 5 {
+			t.Fatalf("expected a length of 5 but got %d", n)
+		}
+	}
+	p, err := buf.ReadFrame()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(p) != "hello" {
+		t.Fatalf("bad: %q", p)
+	}
+
+	t.Run("partial prefix", func(t *testing.T) {
+		buf.Write([]byte{0, 0})
+		if _, err := buf.PeekFrameLen(); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected ErrUnexpectedEOF but got %v", err)
+		}
+		buf.Write([]byte{0, 3})
+		if n, err := buf.PeekFrameLen(); err != nil || n != 3 {
+			t.Fatalf("expected a length of 3 but got %d, %v", n, err)
+		}
+	})
+
+	t.Run("other prefix format", func(t *testing.T) {
+		buf.Reset()
+		opts := circbuf.FrameOptions{PrefixSize: 2, ByteOrder: binary.LittleEndian}
+		buf.WriteFrame([]byte("hi"), opts)
+		if n, err := buf.PeekFrameLen(opts); err != nil || n != 2 {
+			t.Fatalf("expected a length of 2 but got %d, %v", n, err)
+		}
+	})
+}
+
+// point is a record stored as two big endian uint16.
+type point struct {
+	x, y uint16
+}
+
+func (p point) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint16(out, p.x)
+	binary.BigEndian.PutUint16(out[2:], p.y)
+	return out, nil
+}
+
+func (p *point) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return errors.New("invalid point")
+	}
+	p.x = binary.BigEndian.Uint16(data)
+	p.y = binary.BigEndian.Uint16(data[2:])
+	return nil
+}
+
+func TestBuffer_WriteMarshaler(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+16), 2, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	points := []point{{1, 2}, {3, 4}, {5, 6}}
+	for _, p := range points {
+		if err := buf.WriteMarshaler(p); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The first point was overwritten, the reader lands on the second one
+	for _, want := range points[1:] {
+		var p point
+		if err := buf.ReadUnmarshaler(&p); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if p != want {
+			t.Fatalf("bad: %v", p)
+		}
+	}
+	var p point
+	if err := buf.ReadUnmarshaler(&p); err != io.EOF {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf.WriteFrame([]byte("abc"))
+	if err := buf.ReadUnmarshaler(&p); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestBuffer_FramesIter(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+16), 2, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	opts := circbuf.FrameOptions{PrefixSize: 2}
+	it := buf.Frames(opts)
+	if it.Next() {
+		t.Fatalf("expected no frames")
+	}
+
+	// The first frame gets evicted as a whole, the third one wraps
+	// around the end of the ring.
+	for _, f := range []string{"abcd", "efgh", "ijkl", "mn"} {
+		if err := buf.WriteFrame([]byte(f), opts); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	var got []string
+	it = buf.Frames(opts)
+	for it.Next() {
+		got = append(got, string(it.Frame()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(got) != 3 || got[0] != "efgh" || got[1] != "ijkl" || got[2] != "mn" {
+		t.Fatalf("bad: %q", got)
+	}
+
+	// An incomplete frame ends the iteration with an error
+	buf.Reset()
+	buf.WriteFrame([]byte("op"), opts)
+	buf.Write([]byte{0, 9, 'q'})
+	it = buf.Frames(opts)
+	if !it.Next() || string(it.Frame()) != "op" {
+		t.Fatalf("bad: %q", it.Frame())
+	}
+	if it.Next() {
+		t.Fatalf("expected no more frames")
+	}
+	if err := it.Err(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBuffer_WriteFramedTo(t *testing.T) {
+	src, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	src.Write([]byte("hello world"))
+	dst, err := circbuf.NewBuffer(make([]byte, 16), 0, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dst.Write([]byte("old:"))
+
+	r, w := io.Pipe()
+	go func() {
+		_, err := src.WriteFramedTo(w)
+		w.CloseWithError(err)
+	}()
+	n, err := dst.ReadFramedFrom(r)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 16 {
+		t.Fatalf("bad: %v", n)
+	}
+	if s := string(dst.Bytes()); s != "old:lo world" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	// The stream ends before the announced length
+	var framed bytes.Buffer
+	if n, err := src.WriteFramedTo(&framed); n != 16 || err != nil {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	truncated := bytes.NewReader(framed.Bytes()[:12])
+	if n, err := dst.ReadFramedFrom(truncated); n != 12 || err != io.ErrUnexpectedEOF {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if _, err := dst.ReadFramedFrom(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("err: %v", err)
+	}
+}
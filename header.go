@@ -0,0 +1,151 @@
+package circbuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// HeaderSize is the amount of bytes, taken from the skipped region, needed
+// to persist the state of a buffer. See EnableHeaderPersistence.
+const HeaderSize = 20
+
+// headerMagic starts a persisted header, it is followed by the write cursor
+// and the total number of bytes written, both big endian 64 bits integers.
+var headerMagic = []byte("cbuf")
+
+// EnableHeaderPersistence makes the buffer store its write position in the
+// first HeaderSize bytes of the skipped region, on each write and reset, so
+// a buffer set on top of persisted memory, like a memory mapped file, can be
+// restored with NewBufferFromHeader. The offset must be at least HeaderSize.
+func (b *Buffer) EnableHeaderPersistence() error {
+	if b.offset < HeaderSize {
+		return fmt.Errorf("circbuf: offset %d too small for a %d bytes header", b.offset, HeaderSize)
+	}
+	copy(b.data, headerMagic)
+	b.persistHeader = true
+	b.headerCursors = len(headerMagic)
+	b.writeHeader()
+	return nil
+}
+
+// writeHeader stores the write position in the header, if the buffer
+// persists it.
+func (b *Buffer) writeHeader() {
+	if !b.persistHeader {
+		return
+	}
+	h := b.data[b.headerCursors:]
+	binary.BigEndian.PutUint64(h, uint64(b.writeCursor))
+	binary.BigEndian.PutUint64(h[8:], uint64(b.total))
+	b.dirty = true
+}
+
+// NewBufferFromHeader sets a new circular buffer on top of the passed slice
+// of bytes, like NewBuffer does, with header persistence enabled. If the
+// skipped region starts with a header stored by a previous buffer, the
+// write position is restored from it so the retained data is preserved.
+// The read position isn't persisted, all the retained data is unread.
+func NewBufferFromHeader(m []byte, skip, size int64) (*Buffer, error) {
+	if skip < HeaderSize || int64(len(m)) < skip {
+		return nil, fmt.Errorf("circbuf: offset %d too small for a %d bytes header", skip, HeaderSize)
+	}
+	h := m[:HeaderSize]
+	if !bytes.Equal(h[:4], headerMagic) {
+		b, err := NewBuffer(m, skip, size)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.EnableHeaderPersistence(); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	return restoreHeader(m, skip, size, len(headerMagic))
+}
+
+// NewBufferLineAlignedFromHeader is like NewBufferFromHeader for buffers
+// created by NewBufferLineAligned. The line alignment isn't persisted, a
+// buffer restored by NewBufferFromHeader would start with a partial line.
+func NewBufferLineAlignedFromHeader(m []byte, skip, size int64) (*Buffer, error) {
+	b, err := NewBufferFromHeader(m, skip, size)
+	if err != nil {
+		return nil, err
+	}
+	b.lineAligned = true
+	b.SeekToOldest()
+	return b, nil
+}
+
+// restoreHeader sets a new circular buffer with header persistence enabled,
+// restoring the write position stored at the passed position of m.
+func restoreHeader(m []byte, skip, size int64, cursorsAt int) (*Buffer, error) {
+	h := m[cursorsAt:]
+	writeCursor := int64(binary.BigEndian.Uint64(h))
+	written := int64(binary.BigEndian.Uint64(h[8:]))
+	b, err := NewBufferFromExisting(m, skip, size, writeCursor, written)
+	if err != nil {
+		return nil, err
+	}
+	b.persistHeader = true
+	b.headerCursors = cursorsAt
+	return b, nil
+}
+
+// VersionedHeaderSize is the size of the header written at the start of
+// the slice of a versioned buffer, the ring starts right after it.
+const VersionedHeaderSize = 24
+
+var (
+	// ErrHeaderMagic is returned when opening a versioned buffer whose
+	// header doesn't start with the expected magic.
+	ErrHeaderMagic = errors.New("circbuf: header magic mismatch")
+	// ErrHeaderVersion is returned when opening a versioned buffer whose
+	// header has an unexpected version.
+	ErrHeaderVersion = errors.New("circbuf: header version mismatch")
+)
+
+// versionedCursors is the position of the write position in the header of
+// a versioned buffer, after the magic, the version and 3 reserved bytes.
+const versionedCursors = 8
+
+// NewVersionedBuffer sets a new circular buffer of the passed size on top
+// of m, after a header made of magic, version and the write position which
+// is persisted on each write, see EnableHeaderPersistence. A buffer set on
+// top of persisted memory, like a memory mapped file, can be restored with
+// OpenVersionedBuffer, which checks the magic and version first.
+func NewVersionedBuffer(m []byte, size int64, magic [4]byte, version uint8) (*Buffer, error) {
+	if len(m) < VersionedHeaderSize {
+		return nil, fmt.Errorf("circbuf: %d bytes slice too small for a %d bytes header", len(m), VersionedHeaderSize)
+	}
+	b, err := NewBuffer(m, VersionedHeaderSize, size)
+	if err != nil {
+		return nil, err
+	}
+	copy(m, magic[:])
+	m[4] = version
+	fill(m[5:versionedCursors], 0)
+	b.persistHeader = true
+	b.headerCursors = versionedCursors
+	b.writeHeader()
+	return b, nil
+}
+
+// OpenVersionedBuffer restores a buffer created by NewVersionedBuffer from
+// the header found at the start of m. ErrHeaderMagic or ErrHeaderVersion
+// are returned if the header doesn't match the passed magic and version.
+// The read position isn't persisted, all the retained data is unread.
+func OpenVersionedBuffer(m []byte, size int64, magic [4]byte, version uint8) (*Buffer, error) {
+	if len(m) < VersionedHeaderSize {
+		return nil, fmt.Errorf("circbuf: %d bytes slice too small for a %d bytes header", len(m), VersionedHeaderSize)
+	}
+	if !bytes.Equal(m[:4], magic[:]) {
+		return nil, ErrHeaderMagic
+	}
+	if m[4] != version {
+		return nil, ErrHeaderVersion
+	}
+	return restoreHeader(m, VersionedHeaderSize, size, versionedCursors)
+}
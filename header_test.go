@@ -0,0 +1,190 @@
+package circbuf_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestBuffer_EnableHeaderPersistence(t *testing.T) {
+	m := make([]byte, circbuf.HeaderSize+8)
+	buf, err := circbuf.NewBuffer(m, circbuf.HeaderSize-1, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.EnableHeaderPersistence(); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	buf, err = circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello "))
+	buf.Write([]byte("world"))
+
+	// Reopen from the same memory
+	reopened, err := circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "lo world" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := reopened.TotalWritten(); n != 11 {
+		t.Fatalf("bad: %v", n)
+	}
+	reopened.Write([]byte("!"))
+	if s := string(reopened.Bytes()); s != "o world!" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	reopened.Reset()
+	reopened, err = circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := reopened.Len(); n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+}
+
+func TestNewBufferLineAlignedFromHeader(t *testing.T) {
+	m := make([]byte, circbuf.HeaderSize+10)
+	buf, err := circbuf.NewBufferLineAligned(m, circbuf.HeaderSize, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.EnableHeaderPersistence(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("aaa\nbbb\nccc\n"))
+
+	reopened, err := circbuf.NewBufferLineAlignedFromHeader(m, circbuf.HeaderSize, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "bbb\nccc\n" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := reopened.TotalWritten(); n != 12 {
+		t.Fatalf("bad: %v", n)
+	}
+	p, err := reopened.ReadN(10)
+	if string(p) != "bbb\nccc\n" || err != io.ErrUnexpectedEOF {
+		t.Fatalf("bad: %q, %v", p, err)
+	}
+	reopened.Write([]byte("dd\n"))
+	if s := string(reopened.Bytes()); s != "ccc\ndd\n" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestNewBufferLineAlignedFromHeader_Finalized(t *testing.T) {
+	m := make([]byte, circbuf.HeaderSize+16)
+	buf, err := circbuf.NewBufferLineAligned(m, circbuf.HeaderSize, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.EnableHeaderPersistence(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("aaaa\nbbbb\ncccc\ndd\nee\n"))
+	buf.Finalize()
+
+	reopened, err := circbuf.NewBufferLineAlignedFromHeader(m, circbuf.HeaderSize, 16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "cccc\ndd\nee\n" {
+		t.Fatalf("bad: %q", s)
+	}
+	reopened.Write([]byte("ff\n"))
+	if s := string(reopened.Bytes()); s != "cccc\ndd\nee\nff\n" {
+		t.Fatalf("bad: %q", s)
+	}
+}
+
+func TestBuffer_SwapBackingHeader(t *testing.T) {
+	buf, err := circbuf.NewBufferFromHeader(make([]byte, circbuf.HeaderSize+8), circbuf.HeaderSize, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world"))
+
+	grown := make([]byte, circbuf.HeaderSize+16)
+	if err := buf.SwapBacking(grown); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	reopened, err := circbuf.NewBufferFromHeader(grown, circbuf.HeaderSize, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "lo world" {
+		t.Fatalf("bad: %q", s)
+	}
+	if n := reopened.TotalWritten(); n != 11 {
+		t.Fatalf("bad: %v", n)
+	}
+}
+
+func TestNewBufferFromHeader_Corrupt(t *testing.T) {
+	m := make([]byte, circbuf.HeaderSize+8)
+	buf, err := circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("abc"))
+
+	// The write cursor doesn't match the amount written
+	m[4+7] = 5
+	if _, err := circbuf.NewBufferFromHeader(m, circbuf.HeaderSize, 8); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, err := circbuf.NewBufferFromHeader(m, 4, 8); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestVersionedBuffer(t *testing.T) {
+	magic := [4]byte{'L', 'O', 'G', 'S'}
+	m := make([]byte, circbuf.VersionedHeaderSize+8)
+	if _, err := circbuf.NewVersionedBuffer(m[:10], 8, magic, 1); err == nil {
+		t.Fatalf("expected an error")
+	}
+	buf, err := circbuf.NewVersionedBuffer(m, 8, magic, 1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if o := buf.Offset(); o != circbuf.VersionedHeaderSize {
+		t.Fatalf("bad: %v", o)
+	}
+	buf.Write([]byte("hello world"))
+
+	reopened, err := circbuf.OpenVersionedBuffer(m, 8, magic, 1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "lo world" {
+		t.Fatalf("bad: %q", s)
+	}
+	reopened.Write([]byte("!"))
+	reopened, err = circbuf.OpenVersionedBuffer(m, 8, magic, 1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s := string(reopened.Bytes()); s != "o world!" {
+		t.Fatalf("bad: %q", s)
+	}
+
+	if _, err := circbuf.OpenVersionedBuffer(m, 8, [4]byte{'L', 'O', 'G', 'Z'}, 1); err != circbuf.ErrHeaderMagic {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := circbuf.OpenVersionedBuffer(m, 8, magic, 2); err != circbuf.ErrHeaderVersion {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := circbuf.OpenVersionedBuffer(make([]byte, 40), 8, magic, 1); err != circbuf.ErrHeaderMagic {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package circbuf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPartialRecord is returned when writing data which isn't made of whole
+// records to a RecordBuffer.
+var ErrPartialRecord = errors.New("circbuf: write isn't a multiple of the record size")
+
+// RecordBuffer is a circular buffer of fixed size records. Writes must be
+// made of whole records so the oldest records are always evicted as a whole.
+type RecordBuffer struct {
+	b          *Buffer
+	recordSize int64
+}
+
+// NewRecordBuffer sets a new circular buffer of count records of recordSize
+// bytes on top of the passed slice of bytes, after skipping the passed amount
+// of bytes.
+func NewRecordBuffer(m []byte, skip, recordSize, count int64) (*RecordBuffer, error) {
+	if recordSize <= 0 || count <= 0 {
+		return nil, fmt.Errorf("circbuf: invalid record layout, %d records of %d bytes", count, recordSize)
+	}
+	b, err := NewBuffer(m, skip, recordSize*count)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordBuffer{b: b, recordSize: recordSize}, nil
+}
+
+// Write writes the records found in p, overriding the oldest records if
+// necessary. ErrPartialRecord is returned, and nothing is written, if p
+// isn't a multiple of the record size.
+func (rb *RecordBuffer) Write(p []byte) (int, error) {
+	if int64(len(p))%rb.recordSize != 0 {
+		return 0, ErrPartialRecord
+	}
+	return rb.b.Write(p)
+}
+
+// RecordCount returns the number of records retained.
+func (rb *RecordBuffer) RecordCount() int {
+	return int(rb.b.Len() / rb.recordSize)
+}
+
+// RecordAt returns a copy of the i-th retained record, 0 being the oldest.
+func (rb *RecordBuffer) RecordAt(i int) ([]byte, error) {
+	if i < 0 || i >= rb.RecordCount() {
+		return nil, fmt.Errorf("circbuf: record %d out of %d retained records", i, rb.RecordCount())
+	}
+	out := make([]byte, rb.recordSize)
+	rb.b.copyAt(out, int64(i)*rb.recordSize)
+	return out, nil
+}
+
+// RecordAtFromNewest returns a copy of the i-th retained record, 0 being
+// the newest.
+func (rb *RecordBuffer) RecordAtFromNewest(i int) ([]byte, error) {
+	if i < 0 || i >= rb.RecordCount() {
+		return nil, fmt.Errorf("circbuf: record %d out of %d retained records", i, rb.RecordCount())
+	}
+	return rb.RecordAt(rb.RecordCount() - 1 - i)
+}
@@ -0,0 +1,107 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+// record returns a recognizable 8 bytes record.
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record%02d", i))
+}
+
+func TestRecordBuffer(t *testing.T) {
+	rb, err := circbuf.NewRecordBuffer(make([]byte, 4+4*8), 4, 8, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if rb.RecordCount() != 0 {
+		t.Fatalf("bad: %d", rb.RecordCount())
+	}
+
+	for i := 0; i < 10; i++ {
+		n, err := rb.Write(record(i))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n != 8 {
+			t.Fatalf("bad: %d", n)
+		}
+	}
+	// several records at once
+	if _, err := rb.Write(append(record(10), record(11)...)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if rb.RecordCount() != 4 {
+		t.Fatalf("expected 4 records but got %d", rb.RecordCount())
+	}
+	for i := 0; i < 4; i++ {
+		r, err := rb.RecordAt(i)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !bytes.Equal(r, record(8+i)) {
+			t.Fatalf("expected record %d to be %q but got %q", i, record(8+i), r)
+		}
+	}
+
+	for _, i := range []int{-1, 4} {
+		if _, err := rb.RecordAt(i); err == nil {
+			t.Fatalf("expected an error for record %d", i)
+		}
+	}
+}
+
+func TestRecordBuffer_PartialRecord(t *testing.T) {
+	rb, err := circbuf.NewRecordBuffer(make([]byte, 4*8), 0, 8, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	rb.Write(record(0))
+
+	if _, err := rb.Write([]byte("short")); err != circbuf.ErrPartialRecord {
+		t.Fatalf("expected ErrPartialRecord but got %v", err)
+	}
+	if rb.RecordCount() != 1 {
+		t.Fatalf("the partial record was written")
+	}
+
+	if _, err := circbuf.NewRecordBuffer(make([]byte, 32), 0, 0, 4); err == nil {
+		t.Fatal("expected an error for an empty record size")
+	}
+}
+
+func TestRecordBuffer_RecordAtFromNewest(t *testing.T) {
+	rb, err := circbuf.NewRecordBuffer(make([]byte, 3*8), 0, 8, 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := rb.RecordAtFromNewest(0); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := rb.Write(record(i)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for i, want := range []int{4, 3, 2} {
+		r, err := rb.RecordAtFromNewest(i)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !bytes.Equal(r, record(want)) {
+			t.Fatalf("bad: %q", r)
+		}
+	}
+	if _, err := rb.RecordAtFromNewest(3); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, err := rb.RecordAtFromNewest(-1); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,52 @@
+package circbuf
+
+import "fmt"
+
+// Snapshot is a copy of the state of a buffer, independent from its
+// backing memory so it can be safely handed to other goroutines.
+type Snapshot struct {
+	// Data holds a copy of the retained bytes, oldest first.
+	Data []byte
+	// Written is the total number of bytes written to the buffer.
+	Written int64
+	// Size is the size of the ring.
+	Size int64
+	// LineAligned is set for buffers created by NewBufferLineAligned.
+	LineAligned bool
+}
+
+// Snapshot returns a copy of the retained data along with the buffer
+// counters.
+func (b *Buffer) Snapshot() Snapshot {
+	data := make([]byte, b.Len())
+	b.CopyTo(data)
+	return Snapshot{
+		Data:        data,
+		Written:     b.total,
+		Size:        b.size,
+		LineAligned: b.lineAligned,
+	}
+}
+
+// RestoreSnapshot sets a new circular buffer on top of the passed slice of
+// bytes, like NewBuffer does, using the size of the snapshot and restores
+// its content and counters. Fewer bytes than written can be retained, by a
+// line aligned buffer for instance.
+func RestoreSnapshot(s Snapshot, m []byte, skip int64) (*Buffer, error) {
+	retained := s.Written
+	if retained > s.Size {
+		retained = s.Size
+	}
+	if int64(len(s.Data)) > retained {
+		return nil, fmt.Errorf("circbuf: inconsistent snapshot, %d bytes retained out of %d written in a %d bytes ring",
+			len(s.Data), s.Written, s.Size)
+	}
+	b, err := NewBuffer(m, skip, s.Size)
+	if err != nil {
+		return nil, err
+	}
+	b.lineAligned = s.LineAligned
+	b.Write(s.Data)
+	b.total = s.Written
+	return b, nil
+}
@@ -0,0 +1,112 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestBuffer_Snapshot(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inputs []string
+	}{
+		{name: "empty"},
+		{name: "not wrapped", inputs: []string{"hello"}},
+		{name: "wrapped", inputs: []string{"hello ", "world"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			for _, in := range tt.inputs {
+				buf.Write([]byte(in))
+			}
+
+			s := buf.Snapshot()
+			if !bytes.Equal(s.Data, buf.Bytes()) {
+				t.Fatalf("expected %q but got %q", buf.Bytes(), s.Data)
+			}
+			if s.Written != buf.TotalWritten() || s.Size != buf.Size() {
+				t.Fatalf("bad counters: %+v", s)
+			}
+
+			// the snapshot doesn't change with the buffer
+			expect := string(s.Data)
+			buf.Write([]byte("!!!"))
+			if string(s.Data) != expect {
+				t.Fatalf("the snapshot changed: %q", s.Data)
+			}
+
+			restored, err := circbuf.RestoreSnapshot(s, make([]byte, 4+8), 4)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if string(restored.Bytes()) != expect {
+				t.Fatalf("expected %q but got %q", expect, restored.Bytes())
+			}
+			if restored.TotalWritten() != s.Written || restored.Size() != s.Size {
+				t.Fatalf("bad counters: %d, %d", restored.TotalWritten(), restored.Size())
+			}
+
+			restored.Write([]byte("!"))
+			after := expect + "!"
+			if len(after) > 8 {
+				after = after[len(after)-8:]
+			}
+			if string(restored.Bytes()) != after {
+				t.Fatalf("expected %q but got %q", after, restored.Bytes())
+			}
+		})
+	}
+}
+
+func TestBuffer_SnapshotLineAligned(t *testing.T) {
+	buf, err := circbuf.NewBufferLineAligned(make([]byte, 10), 0, 10)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("aaa\nbbb\nccc\n"))
+
+	s := buf.Snapshot()
+	if string(s.Data) != "bbb\nccc\n" || s.Written != 12 || !s.LineAligned {
+		t.Fatalf("bad snapshot: %+v", s)
+	}
+
+	restored, err := circbuf.RestoreSnapshot(s, make([]byte, 10), 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(restored.Bytes()) != "bbb\nccc\n" {
+		t.Fatalf("bad: %q", restored.Bytes())
+	}
+	if n := restored.TotalWritten(); n != 12 {
+		t.Fatalf("bad: %v", n)
+	}
+	restored.Write([]byte("dd\n"))
+	if string(restored.Bytes()) != "ccc\ndd\n" {
+		t.Fatalf("bad: %q", restored.Bytes())
+	}
+}
+
+func TestRestoreSnapshot_Inconsistent(t *testing.T) {
+	testCases := []struct {
+		name string
+		s    circbuf.Snapshot
+	}{
+		{name: "more data than written", s: circbuf.Snapshot{Data: []byte("hello"), Written: 3, Size: 8}},
+		{name: "more data than size", s: circbuf.Snapshot{Data: []byte("hello world"), Written: 11, Size: 8}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := circbuf.RestoreSnapshot(tt.s, make([]byte, 16), 0); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
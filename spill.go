@@ -0,0 +1,74 @@
+package circbuf
+
+import "io"
+
+// SpillBuffer is a circular buffer of frames which writes the frames it
+// evicts to a spill writer, so the newest frames are kept in memory and the
+// older ones go to an overflow log, a rotating file for instance. Frames use
+// the default FrameOptions.
+type SpillBuffer struct {
+	b     *Buffer
+	spill io.Writer
+	// hot is the sequence number of the oldest frame not spilled yet
+	hot int64
+}
+
+// NewSpillBuffer sets a new spilling circular buffer on top of the passed
+// slice of bytes, like NewBuffer does. Evicted frames are written to spill.
+func NewSpillBuffer(m []byte, skip, size int64, spill io.Writer) (*SpillBuffer, error) {
+	b, err := NewBuffer(m, skip, size)
+	if err != nil {
+		return nil, err
+	}
+	return &SpillBuffer{b: b, spill: spill}, nil
+}
+
+// WriteFrame writes p as a frame, like Buffer.WriteFrame does. Beforehand,
+// the oldest frames are written to the spill writer, length prefix included,
+// until the new frame fits, so the spill writer only receives whole frames
+// in the order they were written. If the spill writer fails, its error is
+// returned and p isn't written.
+func (sb *SpillBuffer) WriteFrame(p []byte) error {
+	o, _ := frameOptions(nil)
+	need := int64(o.PrefixSize) + int64(len(p))
+	if need > sb.b.size {
+		return ErrFrameTooLarge
+	}
+	for sb.b.size-(sb.b.total-sb.hot) < need {
+		if err := sb.spillOldest(o); err != nil {
+			return err
+		}
+	}
+	return sb.b.WriteFrame(p)
+}
+
+// spillOldest writes the oldest frame kept in memory to the spill writer.
+func (sb *SpillBuffer) spillOldest(o FrameOptions) error {
+	at := sb.hot - sb.b.OldestSeq()
+	prefix := make([]byte, o.PrefixSize)
+	sb.b.copyAt(prefix, at)
+	frame := make([]byte, int64(o.PrefixSize)+int64(o.len(prefix)))
+	sb.b.copyAt(frame, at)
+	if _, err := sb.spill.Write(frame); err != nil {
+		return err
+	}
+	sb.hot += int64(len(frame))
+	return nil
+}
+
+// Frames returns copies of the payloads of the frames kept in memory,
+// oldest first.
+func (sb *SpillBuffer) Frames() [][]byte {
+	o, _ := frameOptions(nil)
+	var frames [][]byte
+	prefix := make([]byte, o.PrefixSize)
+	for at := sb.hot - sb.b.OldestSeq(); at < sb.b.Len(); {
+		sb.b.copyAt(prefix, at)
+		at += int64(o.PrefixSize)
+		p := make([]byte, o.len(prefix))
+		sb.b.copyAt(p, at)
+		at += int64(len(p))
+		frames = append(frames, p)
+	}
+	return frames
+}
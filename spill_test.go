@@ -0,0 +1,84 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	var spilled bytes.Buffer
+	buf, err := circbuf.NewSpillBuffer(make([]byte, 2+20), 2, 20, &spilled)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	frames := []string{"one", "two", "three", "four", "five", "six"}
+	for _, f := range frames {
+		if err := buf.WriteFrame([]byte(f)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The spilled frames can be read back from the spill writer
+	cold, err := circbuf.NewBuffer(make([]byte, 64), 0, 64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	cold.Write(spilled.Bytes())
+	var got []string
+	for {
+		f, err := cold.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		got = append(got, string(f))
+	}
+	for _, f := range buf.Frames() {
+		got = append(got, string(f))
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("bad: %q", got)
+	}
+	for i := range frames {
+		if got[i] != frames[i] {
+			t.Fatalf("bad: %q", got)
+		}
+	}
+	if n := len(buf.Frames()); n != 2 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	if err := buf.WriteFrame(make([]byte, 17)); err != circbuf.ErrFrameTooLarge {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestSpillBuffer_SpillError(t *testing.T) {
+	buf, err := circbuf.NewSpillBuffer(make([]byte, 12), 0, 12, failingWriter{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.WriteFrame([]byte("abcd")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := buf.WriteFrame([]byte("efgh")); err == nil {
+		t.Fatalf("expected an error")
+	}
+	frames := buf.Frames()
+	if len(frames) != 1 || string(frames[0]) != "abcd" {
+		t.Fatalf("bad: %q", frames)
+	}
+}
@@ -0,0 +1,102 @@
+package circbuf
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// SPSCBuffer is a circular buffer shared, without locking, by exactly one
+// producer goroutine calling Write and one consumer goroutine calling Read.
+// Other methods can be called from either goroutine. Using more than one
+// producer or more than one consumer at a time corrupts the buffer.
+//
+// Unlike a Buffer, it never overwrites unread data: writes stop once the
+// ring is full.
+type SPSCBuffer struct {
+	// written and read are the amounts of bytes ever written and read,
+	// they are accessed atomically and kept first for 64 bits alignment.
+	written int64
+	read    int64
+	data    []byte
+	offset  int64
+	size    int64
+}
+
+// NewSPSCBuffer sets a new single producer single consumer circular buffer
+// on top of the passed slice of bytes, like NewBuffer does.
+func NewSPSCBuffer(m []byte, skip, size int64) (*SPSCBuffer, error) {
+	if err := checkLayout(m, skip, size); err != nil {
+		return nil, err
+	}
+	return &SPSCBuffer{data: m, offset: skip, size: size}, nil
+}
+
+// Write writes as much of p as fits in the free space of the ring. If not
+// all of p could be written, io.ErrShortWrite is returned. It must only be
+// called by the producer.
+func (sb *SPSCBuffer) Write(p []byte) (int, error) {
+	w := atomic.LoadInt64(&sb.written)
+	// The consumer only frees up space, more might be available by the
+	// time the copy is done.
+	free := sb.size - (w - atomic.LoadInt64(&sb.read))
+
+	n := len(p)
+	if int64(n) > free {
+		n = int(free)
+	}
+	sb.copyIn(p[:n], w%sb.size)
+	// Publish the copied bytes to the consumer
+	atomic.StoreInt64(&sb.written, w+int64(n))
+
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Read reads up to len(p) unread bytes, oldest first, into p. io.EOF is
+// returned when there is nothing to read. It must only be called by the
+// consumer.
+func (sb *SPSCBuffer) Read(p []byte) (int, error) {
+	r := atomic.LoadInt64(&sb.read)
+	avail := atomic.LoadInt64(&sb.written) - r
+	if avail == 0 {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+
+	n := len(p)
+	if int64(n) > avail {
+		n = int(avail)
+	}
+	sb.copyOut(p[:n], r%sb.size)
+	// Hand the space back to the producer
+	atomic.StoreInt64(&sb.read, r+int64(n))
+	return n, nil
+}
+
+// Len returns the number of unread bytes.
+func (sb *SPSCBuffer) Len() int64 {
+	// Load read first so the difference is never negative
+	r := atomic.LoadInt64(&sb.read)
+	return atomic.LoadInt64(&sb.written) - r
+}
+
+// Size returns the size of the buffer.
+func (sb *SPSCBuffer) Size() int64 {
+	return sb.size
+}
+
+func (sb *SPSCBuffer) copyIn(p []byte, at int64) {
+	ring := sb.data[sb.offset : sb.offset+sb.size]
+	n := copy(ring[at:], p)
+	copy(ring, p[n:])
+}
+
+func (sb *SPSCBuffer) copyOut(p []byte, at int64) {
+	ring := sb.data[sb.offset : sb.offset+sb.size]
+	n := copy(p, ring[at:])
+	copy(p[n:], ring)
+}
@@ -0,0 +1,88 @@
+package circbuf_test
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestSPSCBuffer(t *testing.T) {
+	buf, err := circbuf.NewSPSCBuffer(make([]byte, 2+4), 2, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out := make([]byte, 8)
+	if n, err := buf.Read(out); n != 0 || err != io.EOF {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+
+	n, err := buf.Write([]byte("abcdef"))
+	if err != io.ErrShortWrite {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("bad: %v", n)
+	}
+
+	n, err = buf.Read(out[:3])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "abc" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+
+	// Wrap around
+	if n, err := buf.Write([]byte("ef")); n != 2 || err != nil {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+	if l := buf.Len(); l != 3 {
+		t.Fatalf("bad: %v", l)
+	}
+	n, err = buf.Read(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "def" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+}
+
+func TestSPSCBuffer_Concurrent(t *testing.T) {
+	buf, err := circbuf.NewSPSCBuffer(make([]byte, 7), 0, 7)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var input bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		input.WriteByte(byte(i % 251))
+	}
+	src := input.Bytes()
+
+	go func() {
+		p := src
+		for len(p) > 0 {
+			n, _ := buf.Write(p)
+			if n == 0 {
+				runtime.Gosched()
+			}
+			p = p[n:]
+		}
+	}()
+
+	var got []byte
+	out := make([]byte, 5)
+	for len(got) < len(src) {
+		n, _ := buf.Read(out)
+		if n == 0 {
+			runtime.Gosched()
+		}
+		got = append(got, out[:n]...)
+	}
+	if !bytes.Equal(got, src) {
+		t.Fatalf("data corrupted")
+	}
+}
@@ -0,0 +1,86 @@
+package circbuf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// BufferState exposes the internal state of a Buffer, for tests and
+// tooling inspecting persisted buffers.
+type BufferState struct {
+	// Data is the backing slice of the buffer, not a copy.
+	Data        []byte
+	Size        int64
+	Offset      int64
+	WriteCursor int64
+	ReadCursor  int64
+	// Written is the number of bytes written, it only grows up to
+	// twice the size, Total is the number of bytes ever written.
+	Written int64
+	Total   int64
+	// Unread is the amount of retained bytes not read yet.
+	Unread int64
+}
+
+// State returns the internal state of the buffer.
+func (b *Buffer) State() BufferState {
+	return BufferState{
+		Data:        b.data,
+		Size:        b.size,
+		Offset:      b.offset,
+		WriteCursor: b.writeCursor,
+		ReadCursor:  b.readCursor,
+		Written:     b.written,
+		Total:       b.total,
+		Unread:      b.unread,
+	}
+}
+
+// Dump returns a human readable description of the buffer state followed
+// by a hex and ASCII rendering of the ring, 16 bytes per line, to help
+// diagnose corrupted buffers. The byte at the write cursor is preceded by
+// a '>' instead of a space. Positions are relative to the ring start.
+func (b *Buffer) Dump() string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "offset=%d size=%d writeCursor=%d readCursor=%d written=%d total=%d unread=%d\n",
+		b.offset, b.size, b.writeCursor, b.readCursor, b.written, b.total, b.unread)
+
+	ring := b.data[b.offset:]
+	if int64(len(ring)) > b.size {
+		ring = ring[:b.size]
+	}
+	for row := 0; row < len(ring); row += 16 {
+		line := ring[row:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		fmt.Fprintf(&out, "%08x ", row)
+		for i := 0; i < 16; i++ {
+			sep := byte(' ')
+			if int64(row+i) == b.writeCursor {
+				sep = '>'
+			}
+			if i < len(line) {
+				fmt.Fprintf(&out, "%c%02x", sep, line[i])
+			} else {
+				out.WriteString("   ")
+			}
+		}
+		out.WriteString("  |")
+		for _, c := range line {
+			if c < 32 || c > 126 {
+				c = '.'
+			}
+			out.WriteByte(c)
+		}
+		out.WriteString("|\n")
+	}
+	return out.String()
+}
+
+// HexDump returns a hex dump, see hex.Dump, of the retained bytes in
+// logical order, oldest first. Use Dump to see the physical layout.
+func (b *Buffer) HexDump() string {
+	return hex.Dump(b.AppendBytes(nil))
+}
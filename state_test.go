@@ -0,0 +1,96 @@
+package circbuf_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/mattetti/circbuf"
+)
+
+func TestBuffer_State(t *testing.T) {
+	data := make([]byte, 2+8)
+	buf, err := circbuf.NewBuffer(data, 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	initial := circbuf.BufferState{Data: data, Size: 8, Offset: 2}
+	if s := buf.State(); !reflect.DeepEqual(s, initial) {
+		t.Fatalf("expected %+v but got %+v", initial, s)
+	}
+
+	buf.Write([]byte("hello"))
+	expect := circbuf.BufferState{Data: data, Size: 8, Offset: 2, WriteCursor: 5, Written: 5, Total: 5, Unread: 5}
+	if s := buf.State(); !reflect.DeepEqual(s, expect) {
+		t.Fatalf("expected %+v but got %+v", expect, s)
+	}
+
+	buf.Write([]byte(" world"))
+	expect = circbuf.BufferState{Data: data, Size: 8, Offset: 2, WriteCursor: 3, Written: 11, Total: 11, Unread: 8}
+	if s := buf.State(); !reflect.DeepEqual(s, expect) {
+		t.Fatalf("expected %+v but got %+v", expect, s)
+	}
+
+	buf.Reset()
+	if s := buf.State(); !reflect.DeepEqual(s, initial) {
+		t.Fatalf("expected %+v but got %+v", initial, s)
+	}
+	if &buf.State().Data[0] != &data[0] {
+		t.Fatal("expected the state to expose the backing slice")
+	}
+}
+
+func TestBuffer_EmptyWrite(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+8), 2, 8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, prefix := range []string{"", "hello", " world"} {
+		buf.Write([]byte(prefix))
+		before := buf.State()
+
+		for _, p := range [][]byte{nil, {}} {
+			n, err := buf.Write(p)
+			if n != 0 || err != nil {
+				t.Fatalf("expected (0, nil) but got (%d, %v)", n, err)
+			}
+			if s := buf.State(); !reflect.DeepEqual(s, before) {
+				t.Fatalf("expected %+v but got %+v", before, s)
+			}
+		}
+	}
+}
+
+func TestBuffer_Dump(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+20), 2, 20)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.Write([]byte("hello world, "))
+	buf.Write([]byte("wrapped!"))
+
+	expect := "offset=2 size=20 writeCursor=1 readCursor=0 written=21 total=21 unread=20\n" +
+		"00000000  21>65 6c 6c 6f 20 77 6f 72 6c 64 2c 20 77 72 61  |!ello world, wra|\n" +
+		"00000010  70 70 65 64                                      |pped|\n"
+	if d := buf.Dump(); d != expect {
+		t.Fatalf("bad:\n%s", d)
+	}
+}
+
+func TestBuffer_HexDump(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 2+20), 2, 20)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d := buf.HexDump(); d != "" {
+		t.Fatalf("bad: %q", d)
+	}
+
+	buf.Write([]byte("hello world, "))
+	buf.Write([]byte("wrapped!\x00\x01"))
+	if d, expect := buf.HexDump(), hex.Dump(buf.Bytes()); d != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, d)
+	}
+}
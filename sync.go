@@ -0,0 +1,172 @@
+package circbuf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrWriteTooLarge is returned when a write waiting for free space is larger
+// than the buffer.
+var ErrWriteTooLarge = errors.New("circbuf: write larger than the buffer")
+
+// SyncBuffer wraps a Buffer so it can safely be used by multiple goroutines.
+type SyncBuffer struct {
+	mu sync.Mutex
+	// cond is signaled when data is written or read
+	cond *sync.Cond
+	b    *Buffer
+	// readDeadline bounds the time ReadContext waits for data
+	readDeadline time.Time
+}
+
+// NewSyncBuffer returns a SyncBuffer wrapping b. b shouldn't be used
+// directly afterwards.
+func NewSyncBuffer(b *Buffer) *SyncBuffer {
+	sb := &SyncBuffer{b: b}
+	sb.cond = sync.NewCond(&sb.mu)
+	return sb
+}
+
+// Write writes p to the buffer and wakes up the blocked readers.
+func (sb *SyncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	n, err := sb.b.Write(p)
+	sb.cond.Broadcast()
+	return n, err
+}
+
+// WriteContext writes p to the buffer without overwriting unread data.
+// If there isn't enough space, it blocks until readers free some up or ctx
+// is done, in which case ctx.Err() is returned and nothing is written.
+// ErrWriteTooLarge is returned if p is larger than the buffer.
+// Only the reads consuming unread data free space: ReadContext, and Read
+// once the buffer is in EOF-on-drain mode, see Buffer.SetEOFOnDrain. By
+// default Read loops over the ring and never frees anything.
+func (sb *SyncBuffer) WriteContext(ctx context.Context, p []byte) (int, error) {
+	defer sb.wakeOnDone(ctx)()
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if int64(len(p)) > sb.b.size {
+		return 0, ErrWriteTooLarge
+	}
+	for sb.b.size-sb.b.unread < int64(len(p)) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		sb.cond.Wait()
+	}
+	n, err := sb.b.Write(p)
+	sb.cond.Broadcast()
+	return n, err
+}
+
+// Read reads from the buffer, see Buffer.Read. Unless the buffer is in
+// EOF-on-drain mode, it doesn't free space for WriteContext.
+func (sb *SyncBuffer) Read(out []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	n, err := sb.b.Read(out)
+	sb.cond.Broadcast()
+	return n, err
+}
+
+// ReadContext reads up to len(out) unread bytes, oldest first, into out.
+// If there is nothing to read, it blocks until data is written or ctx is
+// done, in which case ctx.Err() is returned. The read deadline, if set,
+// also ends the wait, see SetReadDeadline. Once the buffer is closed for
+// writing and everything was read, io.EOF is returned.
+func (sb *SyncBuffer) ReadContext(ctx context.Context, out []byte) (int, error) {
+	if len(out) == 0 {
+		return 0, nil
+	}
+	sb.mu.Lock()
+	deadline := sb.readDeadline
+	sb.mu.Unlock()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	defer sb.wakeOnDone(ctx)()
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for sb.b.unread == 0 {
+		if sb.b.writeClosed {
+			return 0, io.EOF
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		sb.cond.Wait()
+	}
+	n, err := sb.b.drain(out)
+	sb.cond.Broadcast()
+	return n, err
+}
+
+// CloseWrite marks the end of the written data and wakes up the blocked
+// readers, see Buffer.CloseWrite.
+func (sb *SyncBuffer) CloseWrite() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.b.CloseWrite()
+	sb.cond.Broadcast()
+}
+
+// SetReadDeadline sets the time after which ReadContext stops waiting for
+// data and returns context.DeadlineExceeded, whose Timeout method reports
+// true like the errors of a net.Conn. It applies to the reads started after
+// the call. A zero value removes the deadline.
+func (sb *SyncBuffer) SetReadDeadline(t time.Time) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.readDeadline = t
+}
+
+// Bytes returns a copy of the retained data.
+func (sb *SyncBuffer) Bytes() []byte {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	out := make([]byte, sb.b.Len())
+	sb.b.CopyTo(out)
+	return out
+}
+
+// Len returns the number of bytes retained by the buffer.
+func (sb *SyncBuffer) Len() int64 {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.b.Len()
+}
+
+// Reset resets the buffer so it has no content.
+func (sb *SyncBuffer) Reset() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.b.Reset()
+}
+
+// wakeOnDone wakes up the blocked goroutines once ctx is done so they can
+// notice it. The returned function must be called to release resources.
+func (sb *SyncBuffer) wakeOnDone(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stopc := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			sb.mu.Lock()
+			sb.cond.Broadcast()
+			sb.mu.Unlock()
+		case <-stopc:
+		}
+	}()
+	return func() { close(stopc) }
+}
@@ -0,0 +1,244 @@
+package circbuf_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mattetti/circbuf"
+)
+
+func newTestSyncBuffer(t *testing.T, size int64) *circbuf.SyncBuffer {
+	buf, err := circbuf.NewBuffer(make([]byte, size), 0, size)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return circbuf.NewSyncBuffer(buf)
+}
+
+func TestSyncBuffer_Impl(t *testing.T) {
+	var _ io.ReadWriter = &circbuf.SyncBuffer{}
+}
+
+func TestSyncBuffer_ReadContext(t *testing.T) {
+	sb := newTestSyncBuffer(t, 16)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		sb.Write([]byte("hello"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out := make([]byte, 16)
+	n, err := sb.ReadContext(ctx, out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "hello" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+
+	// data already available is returned right away
+	sb.Write([]byte("world"))
+	n, err = sb.ReadContext(ctx, out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "world" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+}
+
+func TestSyncBuffer_ReadContextCancelled(t *testing.T) {
+	sb := newTestSyncBuffer(t, 16)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	n, err := sb.ReadContext(ctx, make([]byte, 16))
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled but got %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	t.Run("already cancelled", func(t *testing.T) {
+		if _, err := sb.ReadContext(ctx, make([]byte, 16)); err != context.Canceled {
+			t.Fatalf("expected context.Canceled but got %v", err)
+		}
+	})
+}
+
+func TestSyncBuffer_WriteContext(t *testing.T) {
+	sb := newTestSyncBuffer(t, 8)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := sb.WriteContext(ctx, []byte("hello")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	read := make(chan string)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		out := make([]byte, 8)
+		n, _ := sb.ReadContext(ctx, out)
+		read <- string(out[:n])
+	}()
+
+	// only 3 bytes are free, the write waits for the reader
+	n, err := sb.WriteContext(ctx, []byte("world"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("bad: %d", n)
+	}
+	if s := <-read; s != "hello" {
+		t.Fatalf("expected the reader to get %q but got %q", "hello", s)
+	}
+	if string(sb.Bytes()) != "lloworld" {
+		t.Fatalf("bad: %q", sb.Bytes())
+	}
+
+	if _, err := sb.WriteContext(ctx, []byte("hello world")); err != circbuf.ErrWriteTooLarge {
+		t.Fatalf("expected ErrWriteTooLarge but got %v", err)
+	}
+}
+
+func TestSyncBuffer_WriteContextRead(t *testing.T) {
+	buf, err := circbuf.NewBuffer(make([]byte, 4), 0, 4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sb := circbuf.NewSyncBuffer(buf)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sb.WriteContext(ctx, []byte("abcd")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the looping Read doesn't free space
+	out := make([]byte, 4)
+	if n, err := sb.Read(out); n != 4 || err != nil {
+		t.Fatalf("bad: %d, %v", n, err)
+	}
+	short, stop := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer stop()
+	if _, err := sb.WriteContext(short, []byte("e")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded but got %v", err)
+	}
+
+	// in EOF-on-drain mode it does
+	buf.SetEOFOnDrain(true)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		sb.Read(out[:2])
+	}()
+	if _, err := sb.WriteContext(ctx, []byte("ef")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(sb.Bytes()) != "cdef" {
+		t.Fatalf("bad: %q", sb.Bytes())
+	}
+}
+
+func TestSyncBuffer_WriteContextCancelled(t *testing.T) {
+	sb := newTestSyncBuffer(t, 8)
+	sb.Write([]byte("hello"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sb.WriteContext(ctx, []byte("world")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded but got %v", err)
+	}
+	if string(sb.Bytes()) != "hello" {
+		t.Fatalf("unread data was overwritten: %q", sb.Bytes())
+	}
+}
+
+func TestSyncBuffer_SetReadDeadline(t *testing.T) {
+	sb := newTestSyncBuffer(t, 16)
+	out := make([]byte, 16)
+
+	sb.SetReadDeadline(time.Now().Add(-time.Second))
+	n, err := sb.ReadContext(context.Background(), out)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("bad: %v", n)
+	}
+	if te, ok := err.(interface{ Timeout() bool }); !ok || !te.Timeout() {
+		t.Fatalf("expected a timeout error")
+	}
+
+	// A blocked read times out
+	sb.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := sb.ReadContext(context.Background(), out); err != context.DeadlineExceeded {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Data available before the deadline is read
+	sb.SetReadDeadline(time.Now().Add(5 * time.Second))
+	sb.Write([]byte("hello"))
+	n, err = sb.ReadContext(context.Background(), out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out[:n]) != "hello" {
+		t.Fatalf("bad: %q", out[:n])
+	}
+
+	sb.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		sb.Write([]byte("world"))
+	}()
+	if _, err := sb.ReadContext(context.Background(), out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestSyncBuffer_CloseWrite(t *testing.T) {
+	sb := newTestSyncBuffer(t, 16)
+
+	go func() {
+		for _, m := range []string{"hello", " world"} {
+			time.Sleep(10 * time.Millisecond)
+			sb.Write([]byte(m))
+		}
+		sb.CloseWrite()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []byte
+	out := make([]byte, 4)
+	for {
+		n, err := sb.ReadContext(ctx, out)
+		got = append(got, out[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("bad: %q", got)
+	}
+
+	if _, err := sb.Write([]byte("late")); err != circbuf.ErrWriteClosed {
+		t.Fatalf("err: %v", err)
+	}
+	if n, err := sb.ReadContext(ctx, out); n != 0 || err != io.EOF {
+		t.Fatalf("bad: %v %v", n, err)
+	}
+}